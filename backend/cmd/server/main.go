@@ -3,10 +3,13 @@ package main
 import (
 	"backend/pkg/api"
 	"backend/pkg/api/post"
+	query "backend/pkg/db/queries"
 	"backend/pkg/db/sqlite"
 	"backend/pkg/middleware"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
@@ -25,13 +28,33 @@ func main() {
 
 	go appCore.Hub.Run()
 
+	// Session lifetimes can be tuned with Go durations, e.g. SESSION_IDLE_TIMEOUT=30m
+	query.SessionIdleTimeout = durationFromEnv("SESSION_IDLE_TIMEOUT", query.SessionIdleTimeout)
+	query.SessionAbsoluteTimeout = durationFromEnv("SESSION_ABSOLUTE_TIMEOUT", query.SessionAbsoluteTimeout)
+	query.SessionRememberMeTimeout = durationFromEnv("SESSION_REMEMBER_ME_TIMEOUT", query.SessionRememberMeTimeout)
+	go query.SweepExpiredSessions(durationFromEnv("SESSION_SWEEP_INTERVAL", time.Hour))
+
 	mux := http.NewServeMux()
 
 	// Authentication routes
 	mux.HandleFunc("/login", api.LoginHandler)
-	mux.HandleFunc("/signup", api.RegisterHandler)
-	mux.HandleFunc("/api/logout", api.LogoutHandler)
+	mux.HandleFunc("/login/2fa", api.LoginTwoFactorHandler)
+	mux.HandleFunc("/signup", api.RegisterHandler(appCore))
+	mux.HandleFunc("/api/logout", api.LogoutHandler(appCore))
 	mux.HandleFunc("/api/check-session", api.CheckSessionHandler)
+	mux.HandleFunc("/api/sessions", middleware.AuthMiddleware(api.SessionsHandler(appCore)))
+	mux.HandleFunc("/api/sessions/revoke-others", middleware.AuthMiddleware(api.RevokeOtherSessionsHandler(appCore)))
+	mux.HandleFunc("/api/2fa", middleware.AuthMiddleware(api.TwoFactorHandler))
+	mux.HandleFunc("/api/2fa/setup", middleware.AuthMiddleware(api.TwoFactorSetupHandler))
+	mux.HandleFunc("/api/2fa/enable", middleware.AuthMiddleware(api.EnableTwoFactorHandler))
+	mux.HandleFunc("/api/2fa/disable", middleware.AuthMiddleware(api.DisableTwoFactorHandler))
+	mux.HandleFunc("/api/2fa/recovery-codes", middleware.AuthMiddleware(api.RecoveryCodesHandler))
+	mux.HandleFunc("/api/password/forgot", api.ForgotPasswordHandler(appCore))
+	mux.HandleFunc("/api/password/reset", api.ResetPasswordHandler(appCore))
+	mux.HandleFunc("/api/email/verify", api.VerifyEmailHandler)
+	mux.HandleFunc("/api/email/verify/resend", middleware.AuthMiddleware(api.ResendVerificationEmailHandler(appCore)))
+	mux.HandleFunc("/api/admin/security-events", middleware.AuthMiddleware(middleware.RequireAdmin(api.SecurityEventsHandler)))
+	mux.HandleFunc("/api/admin/unlock", middleware.AuthMiddleware(middleware.RequireAdmin(api.UnlockHandler)))
 
 	// User routes
 	mux.HandleFunc("/api/user/", api.GetUserHandler)
@@ -39,26 +62,29 @@ func main() {
 	mux.HandleFunc("/images", api.GetImageHandler)
 	mux.HandleFunc("/api/user/update", api.UpdateUserHandler)
 	mux.HandleFunc("/api/top-engaged-users", api.GetTopEngagedUsersHandler)
+	mux.HandleFunc("/api/presence", middleware.AuthMiddleware(api.GetPresenceHandler(appCore)))
+	mux.HandleFunc("/api/presence/settings", middleware.AuthMiddleware(api.PresenceSettingsHandler(appCore)))
+	mux.HandleFunc("/api/blocks", middleware.AuthMiddleware(api.BlocksHandler))
 	mux.HandleFunc("/api/user/posts", post.GetUserPostsHandler)
 	// Post routes
 	mux.HandleFunc("/api/posts", post.GetPostsHandler)
-	mux.HandleFunc("/api/post", post.CreatePostHandler(appCore))
+	mux.HandleFunc("/api/post", middleware.RequireVerifiedEmail(post.CreatePostHandler(appCore)))
 	mux.HandleFunc("/api/post/update", post.UpdatePostHandler)
 	mux.HandleFunc("/api/post/delete", post.DeletePostHandler(appCore))
 	mux.HandleFunc("/api/post/single", post.GetSinglePostHandler)
 
 	// Add these new routes for group posts
-	mux.HandleFunc("/api/group/post", post.CreateGroupPostHandler(appCore))
+	mux.HandleFunc("/api/group/post", middleware.RequireVerifiedEmail(post.CreateGroupPostHandler(appCore)))
 	mux.HandleFunc("/api/group/posts", post.GetGroupPostsHandler)
 
 	// Comment routes
 	mux.HandleFunc("/api/comments", post.GetCommentsHandler)
-	mux.HandleFunc("/api/comment", post.AddCommentHandler(appCore))
+	mux.HandleFunc("/api/comment", middleware.RequireVerifiedEmail(post.AddCommentHandler(appCore)))
 	mux.HandleFunc("/api/comment/update", post.UpdateCommentHandler)
 	//mux.HandleFunc("/api/comment/delete", post.DeleteCommentHandler(appCore))
 
 	// Add new reaction routes
-	mux.HandleFunc("/api/react", middleware.AuthMiddleware(api.ReactHandler(appCore)))
+	mux.HandleFunc("/api/react", middleware.AuthMiddleware(middleware.RequireVerifiedEmail(api.ReactHandler(appCore))))
 	mux.HandleFunc("/api/reactions", middleware.AuthMiddleware(api.GetAvailableReactionsHandler))
 
 	// Group routes
@@ -67,12 +93,12 @@ func main() {
 	mux.HandleFunc("/api/group/details", middleware.AuthMiddleware(api.GetGroupDetailsHandler))
 	mux.HandleFunc("/api/group-requests", api.GroupRequestHandler)
 	mux.HandleFunc("/api/group-joinRequests", api.GroupJoinRequestHandler(appCore))
-	mux.HandleFunc("/api/group/create", api.CreateGroupHandler(appCore))
+	mux.HandleFunc("/api/group/create", middleware.RequireVerifiedEmail(api.CreateGroupHandler(appCore)))
 	mux.HandleFunc("/api/group/update", middleware.AuthMiddleware(api.UpdateGroupHandler))
 	mux.HandleFunc("/api/group/delete", middleware.AuthMiddleware(api.DeleteGroupHandler(appCore)))
 
 	// Update the Follow routes to pass appCore
-	mux.HandleFunc("/api/Follow", api.InitFollowHandler(appCore))
+	mux.HandleFunc("/api/Follow", middleware.RequireVerifiedEmail(api.InitFollowHandler(appCore)))
 	mux.HandleFunc("/api/Following/", api.FollowingHandler)
 	mux.HandleFunc("/api/Followers/", api.FollowersHandler)
 	mux.HandleFunc("/api/Follow-requests", api.FollowRequestHandler)
@@ -81,7 +107,7 @@ func main() {
 
 	// Event routes
 	mux.HandleFunc("/api/events", api.GetEventsHandler)
-	mux.HandleFunc("/api/event", api.CreateEventHandler(appCore))
+	mux.HandleFunc("/api/event", middleware.RequireVerifiedEmail(api.CreateEventHandler(appCore)))
 	mux.HandleFunc("/api/event/respond", api.RespondToEventHandler)
 	mux.HandleFunc("/api/event/responses", api.GetEventResponsesHandler)
 
@@ -95,24 +121,35 @@ func main() {
 
 	// Chat routes
 	mux.HandleFunc("/ws", api.InitWebSocketConnectionHandler(appCore.Hub))
-	mux.HandleFunc("/api/chat", api.GetChatHandler)
+	mux.HandleFunc("/api/stream", api.InitEventStreamHandler(appCore.Hub))
+	mux.HandleFunc("/api/chat", api.ChatHandler(appCore))
+	mux.HandleFunc("/api/chat/edits", api.GetMessageEditsHandler)
+	mux.HandleFunc("/api/chat/react", middleware.AuthMiddleware(middleware.RequireVerifiedEmail(api.ReactToChatMessageHandler(appCore))))
+	mux.HandleFunc("/api/chat/attachment", api.GetChatAttachmentHandler)
+	mux.HandleFunc("/api/chat/search", api.SearchMessagesHandler)
 	mux.HandleFunc("/api/chat-group", api.GetGroupChatHandler)
 	mux.HandleFunc("/api/chats", api.GetAllChatsHandler)
+	mux.HandleFunc("/api/chat/conversations", api.GetConversationsHandler)
+	mux.HandleFunc("/api/chat/settings", api.ConversationSettingsHandler)
+	mux.HandleFunc("/api/chat/requests", api.MessageRequestsHandler(appCore))
 	mux.HandleFunc("/api/chat/newusers", api.GetNewChatUsersHandler)
-	mux.HandleFunc("/api/chat/send", api.SendMessageHandler(appCore))
-	mux.HandleFunc("/api/chat/mark-read", api.MarkMessageAsReadHandler)
+	mux.HandleFunc("/api/chat/send", middleware.RequireVerifiedEmail(api.SendMessageHandler(appCore)))
+	mux.HandleFunc("/api/chat/mark-read", api.MarkMessageAsReadHandler(appCore))
 	//mux.HandleFunc("/api/chat/allow-chat", api.CheckIfAllowChat)
 	//mux.HandleFunc("/api/chat/send-group", api.SendGroupMessageHandler(appCore))
 	//mux.HandleFunc("/api/chat/history", api.GetChatHistoryHandler)
 	//mux.HandleFunc("/api/chat/active", api.GetActiveChatsHandler)
 
 	// Group invitation routes
-	mux.HandleFunc("/api/group/invite", middleware.AuthMiddleware(api.InviteUsersHandler(appCore)))
+	mux.HandleFunc("/api/group/invite", middleware.AuthMiddleware(middleware.RequireVerifiedEmail(api.InviteUsersHandler(appCore))))
 	mux.HandleFunc("/api/group/cancel-invite", middleware.AuthMiddleware(api.CancelInvitationHandler(appCore)))
 	mux.HandleFunc("/api/group/invite-list", middleware.AuthMiddleware(api.GetGroupInvitationListHandler))
 	mux.HandleFunc("/api/group/invite/accept", api.AcceptInvitationHandler)
 	mux.HandleFunc("/api/group/invite/reject", api.RejectInvitationHandler)
 	mux.HandleFunc("/api/group/members/remove", api.RemoveMembersHandler)
+	mux.HandleFunc("/api/group/chat/settings", middleware.AuthMiddleware(api.GroupChatSettingsHandler(appCore)))
+	mux.HandleFunc("/api/group/chat/admins", middleware.AuthMiddleware(api.GroupChatAdminsHandler(appCore)))
+	mux.HandleFunc("/api/group/chat/pins", middleware.AuthMiddleware(api.PinnedMessagesHandler(appCore)))
 
 	// Apply middlewares
 	handler := middleware.CorsMiddleware(mux)
@@ -127,3 +164,17 @@ func main() {
 	}
 
 }
+
+// durationFromEnv reads a positive duration from the environment variable name,
+// falling back to fallback when it is not set
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Fatalf("Invalid %s %q: must be a positive duration such as 30m or 24h\n", name, value)
+	}
+	return duration
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
+	"backend/pkg/websocket"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sendVerificationEmail emails the user a link confirming their address. The email is
+// sent in the background so a slow mail server does not hold up the request.
+func sendVerificationEmail(appCore *middleware.AppCore, userID int, email string) error {
+	token, err := query.CreateUserToken(userID, query.TokenEmailVerification, query.EmailVerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appCore.AppURL + "/verify-email?token=" + url.QueryEscape(token)
+	go func() {
+		body := "Welcome to the social network!\n\n" +
+			"Confirm your email address by opening the link below:\n\n" + link + "\n\n" +
+			"The link expires in 48 hours. If you did not create an account, ignore this email.\n"
+		if err := appCore.Mailer.Send(email, "Confirm your email address", body); err != nil {
+			log.Printf("Error sending verification email to user %d: %v", userID, err)
+		}
+	}()
+	return nil
+}
+
+// sendPasswordResetEmail emails a password reset link if an account uses the email.
+// It runs after the response is sent, so how long it takes does not tell whether
+// the account exists.
+func sendPasswordResetEmail(appCore *middleware.AppCore, email string) {
+	user, err := query.GetUserByEmailOrUsername(email)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up user for password reset: %v", err)
+		}
+		return
+	}
+	if !strings.EqualFold(user.Email, email) {
+		return // Matched a username, the link only goes to the address typed in
+	}
+
+	token, err := query.CreateUserToken(user.ID, query.TokenPasswordReset, query.PasswordResetTokenTTL)
+	if err != nil {
+		return
+	}
+	link := appCore.AppURL + "/reset-password?token=" + url.QueryEscape(token)
+	body := "Someone asked to reset the password of your account " + user.Username + ".\n\n" +
+		"Choose a new password by opening the link below:\n\n" + link + "\n\n" +
+		"The link expires in 1 hour. If you did not ask for it, ignore this email, your password stays the same.\n"
+	if err := appCore.Mailer.Send(user.Email, "Reset your password", body); err != nil {
+		log.Printf("Error sending password reset email to user %d: %v", user.ID, err)
+	}
+}
+
+// ForgotPasswordHandler emails a password reset link to the account with the given
+// email. The response is the same whether or not the account exists, so it cannot
+// be used to find out who is registered.
+func ForgotPasswordHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		email := strings.TrimSpace(request.Email)
+		if email == "" {
+			http.Error(w, "Email is required", http.StatusBadRequest)
+			return
+		}
+
+		if !throttleEmail(w, r, 0, email) {
+			return
+		}
+		go sendPasswordResetEmail(appCore, email)
+
+		sendJSONResponse(w, map[string]string{
+			"message": "If an account uses this email, a link to reset the password has been sent to it",
+		})
+	}
+}
+
+// ResetPasswordHandler sets a new password with a token from a reset email. Every
+// session of the user is ended, so whoever knew the old password is logged out.
+func ResetPasswordHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Token == "" || strings.TrimSpace(request.Password) == "" {
+			http.Error(w, "Token and password are required", http.StatusBadRequest)
+			return
+		}
+
+		// Hash before using up the token, so a failure here leaves the link working
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Could not hash password", http.StatusInternalServerError)
+			return
+		}
+
+		userID, err := query.ConsumeUserToken(request.Token, query.TokenPasswordReset)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+			} else {
+				http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := query.UpdateUserPassword(userID, string(hashedPassword)); err != nil {
+			http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+			return
+		}
+
+		// The email reached the user, which also proves the address is theirs
+		if err := query.MarkEmailVerified(userID); err != nil {
+			log.Printf("Error verifying email of user %d after password reset: %v", userID, err)
+		}
+		// Failed logins with the old password no longer keep the owner out
+		query.ClearAttempts(accountThrottleKey(userID))
+		query.LogSecurityEvent(securityEvent(r, query.EventPasswordReset, userID, "", ""))
+
+		sessionIDs, err := query.RevokeOtherSessions(userID, "")
+		if err != nil {
+			http.Error(w, "Failed to end sessions", http.StatusInternalServerError)
+			return
+		}
+		for _, sessionID := range sessionIDs {
+			websocket.DisconnectSession(appCore.Hub, sessionID)
+		}
+
+		sendJSONResponse(w, map[string]string{"message": "Password updated, you can now log in"})
+	}
+}
+
+// VerifyEmailHandler confirms the user's email address with a token from a
+// verification email
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := query.ConsumeUserToken(request.Token, query.TokenEmailVerification)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := query.MarkEmailVerified(userID); err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, map[string]string{"message": "Email verified"})
+}
+
+// ResendVerificationEmailHandler sends the logged in user a new verification email,
+// the links of the earlier ones stop working
+func ResendVerificationEmailHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if user.EmailVerified {
+			http.Error(w, "Email is already verified", http.StatusConflict)
+			return
+		}
+		if !throttleEmail(w, r, user.ID, user.Email) {
+			return
+		}
+
+		if err := sendVerificationEmail(appCore, user.ID, user.Email); err != nil {
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, map[string]string{"message": "Verification email sent"})
+	}
+}
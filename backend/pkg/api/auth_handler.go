@@ -2,8 +2,11 @@ package api
 
 import (
 	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
 	"backend/pkg/models"
 	"backend/pkg/utilities"
+	"backend/pkg/websocket"
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -22,6 +25,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var credentials struct {
 		EmailOrUsername string `json:"emailOrUsername"`
 		Password        string `json:"password"`
+		RememberMe      bool   `json:"rememberMe"` // Keep the session for a long time, even when unused
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
@@ -30,11 +34,55 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := query.GetUserByEmailOrUsername(credentials.EmailOrUsername)
-	if err != nil {
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving user for login: %v", err)
+		http.Error(w, "Error checking credentials", http.StatusInternalServerError)
+		return
+	}
+	found := err == nil
+
+	// Whether the account exists or not, and whether it is named by username or email,
+	// the attempt is throttled and checked the same way
+	attempts := loginAttempts(r, loginThrottleKey(user, found, credentials.EmailOrUsername))
+	lockouts, ok := takeAttempts(w, attempts...)
+	if !ok {
+		return
+	}
+
+	passwordHash := dummyPasswordHash
+	if found {
+		passwordHash = []byte(user.Password)
+	}
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(credentials.Password)); err != nil || !found {
+		recordLoginFailure(r, query.EventLoginFailed, user.ID, credentials.EmailOrUsername, lockouts)
 		sendErrorResponse(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	refundAttempts(attempts, lockouts)
+
+	// With 2FA on, the password only lets the client ask for the code, the session is
+	// created by LoginTwoFactorHandler
+	if user.TwoFactor {
+		token, err := query.CreateLoginChallenge(user.ID, credentials.RememberMe)
+		if err != nil {
+			http.Error(w, "Could not start login", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, map[string]interface{}{
+			"success":           false,
+			"twoFactorRequired": true,
+			"twoFactorToken":    token,
+		})
+		return
+	}
 
+	completeLogin(w, r, user, credentials.RememberMe)
+}
+
+// completeLogin creates the session of a user who proved who they are, sets its
+// cookie and sends the user back
+func completeLogin(w http.ResponseWriter, r *http.Request, user models.User, rememberMe bool) {
+	var err error
 	user.Following, user.Followers, err = query.GetUserStats(user.ID)
 	if err != nil {
 		http.Error(w, "Error checking following and followers", http.StatusInternalServerError)
@@ -47,27 +95,22 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Remove any existing session for the user
-	if err := query.RemoveExistingSession(user.ID); err != nil {
-		http.Error(w, "Could not remove existing session", http.StatusInternalServerError)
-		return
-	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password)); err != nil {
-		sendErrorResponse(w, "Invalid credentials", http.StatusUnauthorized)
-		return
-	}
-
-	sessionID, err := query.CreateSession(user.ID)
+	// Every device gets its own session, logging in does not end the others
+	sessionID, expiresAt, err := query.CreateSession(user.ID, r.UserAgent(), utilities.ClientIP(r), rememberMe)
 	if err != nil {
 		http.Error(w, "Could not create session", http.StatusInternalServerError)
 		return
 	}
 
+	// The failed attempts before it no longer count towards a lockout of the account
+	query.ClearAttempts(accountThrottleKey(user.ID))
+	query.LogSecurityEvent(securityEvent(r, query.EventLoginSucceeded, user.ID, "", ""))
+
+	// The cookie lives as long as the session can, the server enforces the idle timeout
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_id",
 		Value:    sessionID,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  expiresAt,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteNoneMode,
@@ -83,110 +126,136 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max memory
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+// RegisterHandler creates the account and logs it in, then emails a link to verify
+// the address
+func RegisterHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Every signup counts, so one address cannot create accounts in bulk or probe
+		// which usernames and emails are taken
+		lockouts, ok := takeAttempts(w, query.Attempt{Key: signupIPThrottleKey(utilities.ClientIP(r)), Throttle: query.SignupThrottle})
+		if !ok {
+			return
+		}
+		if until := lockouts[0]; !until.IsZero() {
+			query.LogSecurityEvent(securityEvent(r, query.EventSignupLocked, 0, "",
+				"locked until "+until.Format(time.RFC3339)))
+		}
 
-	user := models.User{
-		Username:    r.FormValue("username"),
-		Email:       r.FormValue("email"),
-		Password:    r.FormValue("password"),
-		FirstName:   r.FormValue("firstName"),
-		LastName:    r.FormValue("lastName"),
-		Nickname:    r.FormValue("nickname"),
-		DateOfBirth: r.FormValue("dateOfBirth"),
-		AboutMe:     r.FormValue("aboutMe"),
-	}
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max memory
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user := models.User{
+			Username:    r.FormValue("username"),
+			Email:       r.FormValue("email"),
+			Password:    r.FormValue("password"),
+			FirstName:   r.FormValue("firstName"),
+			LastName:    r.FormValue("lastName"),
+			Nickname:    r.FormValue("nickname"),
+			DateOfBirth: r.FormValue("dateOfBirth"),
+			AboutMe:     r.FormValue("aboutMe"),
+		}
+
+		// Handle file upload
+		file, header, err := r.FormFile("profileImg")
+		if err == nil {
+			defer file.Close()
+			filename, err := utilities.SaveFile(file, header)
+			if err != nil {
+				sendErrorResponse(w, "Error saving file", http.StatusInternalServerError)
+				return
+			}
+
+			user.AvatarURL = filename
+		}
 
-	// Handle file upload
-	file, header, err := r.FormFile("profileImg")
-	if err == nil {
-		defer file.Close()
-		filename, err := utilities.SaveFile(file, header)
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 		if err != nil {
-			sendErrorResponse(w, "Error saving file", http.StatusInternalServerError)
+			http.Error(w, "Could not hash password", http.StatusInternalServerError)
 			return
 		}
+		user.Password = string(hashedPassword)
 
-		user.AvatarURL = filename
-	}
-
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Could not hash password", http.StatusInternalServerError)
-		return
-	}
-	user.Password = string(hashedPassword)
+		err = utilities.ValidateUser(user)
+		if err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	err = utilities.ValidateUser(user)
-	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+		if !isValidUsername(user.Username) {
+			http.Error(w, "Invalid username. Use only letters, numbers, underscores, and hyphens.", http.StatusBadRequest)
+			return
+		}
 
-	if !isValidUsername(user.Username) {
-		http.Error(w, "Invalid username. Use only letters, numbers, underscores, and hyphens.", http.StatusBadRequest)
-		return
-	}
+		// Create the user and get the user ID
+		userID, err := query.CreateUser(user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-	// Create the user and get the user ID
-	userID, err := query.CreateUser(user)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+		// The account is restricted until the address is confirmed, failing to send the
+		// email is not fatal as the user can ask for another one
+		if err := sendVerificationEmail(appCore, userID, user.Email); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
 
-	// Create a session for the new user
-	sessionID, err := query.CreateSession(userID)
-	if err != nil {
-		http.Error(w, "Could not create session", http.StatusInternalServerError)
-		return
-	}
+		// Create a session for the new user
+		sessionID, expiresAt, err := query.CreateSession(userID, r.UserAgent(), utilities.ClientIP(r), false)
+		if err != nil {
+			http.Error(w, "Could not create session", http.StatusInternalServerError)
+			return
+		}
 
-	// Set the session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-		Path:     "/",
-	})
+		// Set the session cookie
+		http.SetCookie(w, &http.Cookie{
+			Name:     "session_id",
+			Value:    sessionID,
+			Expires:  expiresAt,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+			Path:     "/",
+		})
 
-	// Send a JSON response with user data
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Signup successful",
-		"user":    user.SafeUser(),
-	})
+		// Send a JSON response with user data
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Signup successful",
+			"user":    user.SafeUser(),
+		})
+	}
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		http.Error(w, "No session found", http.StatusBadRequest)
-		return
-	}
+func LogoutHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			http.Error(w, "No session found", http.StatusBadRequest)
+			return
+		}
 
-	if err := query.DeleteSession(cookie.Value); err != nil {
-		http.Error(w, "Could not delete session", http.StatusInternalServerError)
-		return
-	}
+		if err := query.DeleteSession(cookie.Value); err != nil {
+			http.Error(w, "Could not delete session", http.StatusInternalServerError)
+			return
+		}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Expires:  time.Now().Add(-1 * time.Hour),
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	})
+		// Close any WebSocket connections opened with this session
+		websocket.DisconnectSession(appCore.Hub, cookie.Value)
 
-	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+		http.SetCookie(w, &http.Cookie{
+			Name:     "session_id",
+			Value:    "",
+			Expires:  time.Now().Add(-1 * time.Hour),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+	}
 }
 
 func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
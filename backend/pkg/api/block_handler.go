@@ -0,0 +1,79 @@
+package api
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// BlocksHandler lists (GET), adds (POST) or lifts (DELETE ?userId=) the blocks and
+// mutes the user put on other users. A blocked user and the blocker no longer see
+// each other's posts and comments, cannot follow or message each other and get no
+// notifications from each other. A muted user's content is only hidden from the muter.
+func BlocksHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		blocked, err := query.GetBlockedUsers(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch blocked users", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, blocked)
+	case http.MethodPost:
+		var request struct {
+			UserID int    `json:"userId"`
+			Type   string `json:"type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Type == "" {
+			request.Type = query.BlockTypeBlock
+		}
+		if request.Type != query.BlockTypeBlock && request.Type != query.BlockTypeMute {
+			http.Error(w, "Type must be block or mute", http.StatusBadRequest)
+			return
+		}
+		if request.UserID == user.ID {
+			http.Error(w, "You cannot block yourself", http.StatusBadRequest)
+			return
+		}
+		if _, err := query.GetUserByID(request.UserID); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "User not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to get user", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := query.BlockUser(user.ID, request.UserID, request.Type); err != nil {
+			http.Error(w, "Failed to block user", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, map[string]interface{}{"userId": request.UserID, "type": request.Type})
+	case http.MethodDelete:
+		blockedID, err := strconv.Atoi(r.URL.Query().Get("userId"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if err := query.UnblockUser(user.ID, blockedID); err != nil {
+			http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -4,11 +4,22 @@ import (
 	query "backend/pkg/db/queries"
 	"backend/pkg/middleware"
 	"backend/pkg/models"
+	"backend/pkg/utilities"
 	"backend/pkg/websocket"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func InitWebSocketConnectionHandler(hub *websocket.Hub) http.HandlerFunc {
@@ -17,6 +28,13 @@ func InitWebSocketConnectionHandler(hub *websocket.Hub) http.HandlerFunc {
 	}
 }
 
+// InitEventStreamHandler serves the Server-Sent Events fallback for clients that cannot open a WebSocket
+func InitEventStreamHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		websocket.ServeSSE(hub, w, r)
+	}
+}
+
 func GetAllChatsHandler(w http.ResponseWriter, r *http.Request) {
 	// Right now this only return the latest message in the chat because I do not see the value in sending everything.
 	// If for some reason you want everything, uncomment the GetAllChatQuery
@@ -28,7 +46,7 @@ func GetAllChatsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Fetch notifications from the database
 	//chats, err := query.GetAllChatQuery(user.ID) // Probably do a url variable to toggle this on
-	chats, err := query.GetLastMessageOfAllChatQuery(user.ID)
+	chats, err := query.GetLastMessageOfAllChatQuery(user.ID, false)
 	if err != nil {
 		fmt.Println(err.Error())
 		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
@@ -44,6 +62,287 @@ func GetAllChatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ChatHandler returns a private chat on GET, and edits (PUT) or deletes (DELETE)
+// one of the user's own private or group messages.
+func ChatHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			GetChatHandler(w, r)
+		case http.MethodPut:
+			editChatMessage(appCore, w, r)
+		case http.MethodDelete:
+			deleteChatMessage(appCore, w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Longest content of the latest message shown in the conversation list, in characters
+const conversationPreviewLength = 100
+
+// GetConversationsHandler lists the user's conversations, most recently active first,
+// with a preview of the latest message and the unread count of each. Archived
+// conversations are listed instead of the others with ?archived=true.
+func GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	archived := r.URL.Query().Get("archived") == "true"
+	chats, err := query.GetLastMessageOfAllChatQuery(user.ID, archived)
+	if err != nil {
+		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
+		return
+	}
+
+	// Let the list show an attachment-only message as such
+	lastMessages := make([]models.ChatMessage, len(chats))
+	for i, chat := range chats {
+		lastMessages[i] = chat.Messages[0]
+	}
+	if err := query.AddChatAttachments(lastMessages); err != nil {
+		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
+		return
+	}
+
+	conversations := []models.Conversation{}
+	for i, chat := range chats {
+		chat.Messages[0] = lastMessages[i]
+		conversation := models.Conversation{
+			LastMessage: chat.Messages[0],
+			Unread:      chat.Notification,
+			Muted:       chat.Muted,
+			Archived:    chat.Archived,
+		}
+		if content := []rune(conversation.LastMessage.Content); len(content) > conversationPreviewLength {
+			conversation.LastMessage.Content = string(content[:conversationPreviewLength]) + "…"
+		}
+		if chat.Group.ID != 0 {
+			group := chat.Group
+			group.Members = nil // The list only needs the group's name and image
+			conversation.Group = &group
+		} else {
+			userB := chat.UserB
+			conversation.UserB = &userB
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(conversations); err != nil {
+		http.Error(w, "Failed to encode conversations", http.StatusInternalServerError)
+	}
+}
+
+// ConversationSettingsHandler reads (GET) or changes (POST) how the user set up the
+// private chat with ?userId= or the group chat ?groupId=: muted, optionally until a
+// given time, and archived. POST takes the full settings, mutedUntil being optional.
+func ConversationSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var settings models.ConversationSettings
+	switch r.Method {
+	case http.MethodGet:
+		settings.UserID, _ = strconv.Atoi(r.URL.Query().Get("userId"))
+		settings.GroupID, _ = strconv.Atoi(r.URL.Query().Get("groupId"))
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if settings.Muted && settings.MutedUntil != nil && !settings.MutedUntil.After(time.Now()) {
+			http.Error(w, "mutedUntil must be in the future", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if (settings.UserID <= 0) == (settings.GroupID <= 0) || settings.UserID == user.ID {
+		http.Error(w, "Exactly one of userId or groupId is required", http.StatusBadRequest)
+		return
+	}
+	if settings.GroupID != 0 {
+		status, err := query.GetMemberStatus(user.ID, settings.GroupID)
+		if err != nil {
+			http.Error(w, "Failed to check user group member status", http.StatusInternalServerError)
+			return
+		}
+		if status != "accepted" {
+			http.Error(w, "You are not part of the group", http.StatusForbidden)
+			return
+		}
+	} else if _, err := query.GetUserItemByID(settings.UserID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if err := query.SaveConversationSettings(user.ID, settings); err != nil {
+			http.Error(w, "Failed to save conversation settings", http.StatusInternalServerError)
+			return
+		}
+	}
+	settings, err = query.GetConversationSettings(user.ID, settings.UserID, settings.GroupID)
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		http.Error(w, "Failed to encode conversation settings", http.StatusInternalServerError)
+	}
+}
+
+// MessageRequestsHandler lists the message requests waiting for the user (GET), or
+// those they declined with ?status=declined, and accepts or declines the request of
+// a user (POST {userId, accept}). An accepted request becomes a normal conversation.
+func MessageRequestsHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := middleware.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			status := r.URL.Query().Get("status")
+			if status == "" {
+				status = query.MessageRequestPending
+			}
+			if status != query.MessageRequestPending && status != query.MessageRequestDeclined {
+				http.Error(w, "Status must be pending or declined", http.StatusBadRequest)
+				return
+			}
+			requests, err := query.GetMessageRequests(user.ID, status)
+			if err != nil {
+				http.Error(w, "Failed to fetch message requests", http.StatusInternalServerError)
+				return
+			}
+			sendJSONResponse(w, requests)
+		case http.MethodPost:
+			var request struct {
+				UserID int  `json:"userId"`
+				Accept bool `json:"accept"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			status := query.MessageRequestDeclined
+			if request.Accept {
+				status = query.MessageRequestAccepted
+			}
+			if err := query.RespondToMessageRequest(request.UserID, user.ID, status); err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, "Message request not found", http.StatusNotFound)
+				} else {
+					http.Error(w, "Failed to update message request", http.StatusInternalServerError)
+				}
+				return
+			}
+			websocket.SendMessageRequestUpdate(appCore.Hub, request.UserID, user.ID, status)
+			sendJSONResponse(w, map[string]interface{}{"userId": request.UserID, "status": status})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Number of search results returned per page when no limit is given, and the most allowed
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 50
+)
+
+// SearchMessagesHandler searches the messages of every conversation the user is part of.
+// Results are ranked by relevance and paged with limit and offset.
+func SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	if search == "" || len(search) > 200 {
+		http.Error(w, "Search must be between 1 and 200 characters", http.StatusBadRequest)
+		return
+	}
+	limit, offset := defaultSearchPageSize, 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchPageSize {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", maxSearchPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, hasMore, err := query.SearchMessages(user.ID, search, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Results []models.MessageSearchResult `json:"results"`
+		HasMore bool                         `json:"hasMore"`
+	}{
+		Results: results,
+		HasMore: hasMore,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode search results", http.StatusInternalServerError)
+	}
+}
+
+// Number of messages returned per page of chat history when no limit is given, and the most allowed
+const (
+	defaultChatPageSize = 50
+	maxChatPageSize     = 100
+)
+
+// parseChatPage reads the optional before cursor, the ID of the oldest message the
+// client already has, and the page limit from the query string.
+func parseChatPage(r *http.Request) (int, int, error) {
+	before, limit := 0, defaultChatPageSize
+	var err error
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err = strconv.Atoi(value)
+		if err != nil || before < 0 {
+			return 0, 0, errors.New("Invalid before cursor")
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxChatPageSize {
+			return 0, 0, fmt.Errorf("Limit must be between 1 and %d", maxChatPageSize)
+		}
+	}
+	return before, limit, nil
+}
+
 func GetChatHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := middleware.GetAuthenticatedUser(r)
 	if err != nil {
@@ -61,13 +360,25 @@ func GetChatHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to find user", http.StatusInternalServerError)
 		return
 	}
+	before, limit, err := parseChatPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Fetch chat from the database
-	chat, err := query.GetChatQuery(user.ID, userBId)
+	chat, err := query.GetChatQuery(user.ID, userBId, before, limit)
 	if err != nil {
 		fmt.Println(err.Error())
 		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
 		return
 	}
+	settings, err := query.GetConversationSettings(user.ID, userBId, 0)
+	if err != nil {
+		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
+		return
+	}
+	chat.Muted, chat.Archived = settings.Muted, settings.Archived
 
 	// Set the response header to application/json
 	w.Header().Set("Content-Type", "application/json")
@@ -87,12 +398,29 @@ func GetGroupChatHandler(w http.ResponseWriter, r *http.Request) {
 
 	groupId, err := strconv.Atoi(r.URL.Query().Get("groupId"))
 	if err != nil {
-		http.Error(w, "Invalid User ID", http.StatusBadRequest)
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	// Only accepted members of the group can read its chat
+	member, err := query.IsChatParticipant(user.ID, models.ChatMessage{GroupID: groupId})
+	if err != nil {
+		http.Error(w, "Failed to check chat permissions", http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		http.Error(w, "You are not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	before, limit, err := parseChatPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Fetch notifications from the database
-	chat, err := query.GetGroupChatQuery(groupId)
+	chat, err := query.GetGroupChatQuery(groupId, before, limit)
 	if err != nil {
 		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
 		return
@@ -103,6 +431,23 @@ func GetGroupChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	chat.UserA = userA
+	settings, err := query.GetConversationSettings(user.ID, 0, groupId)
+	if err != nil {
+		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
+		return
+	}
+	chat.Muted, chat.Archived = settings.Muted, settings.Archived
+	groupSettings, err := query.GetGroupChatSettings(groupId)
+	if err != nil {
+		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
+		return
+	}
+	chat.Settings = &groupSettings
+	chat.AllowChat, err = query.CanSendChatMessage(user.ID, 0, groupId)
+	if err != nil {
+		http.Error(w, "Failed to fetch chats", http.StatusInternalServerError)
+		return
+	}
 
 	// Set the response header to application/json
 	w.Header().Set("Content-Type", "application/json")
@@ -113,20 +458,438 @@ func GetGroupChatHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func SendMessageHandler(appCore *middleware.AppCore) http.HandlerFunc {
+func editChatMessage(appCore *middleware.AppCore, w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var edit struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	edit.Content = strings.TrimSpace(edit.Content)
+	if edit.Content == "" || len(edit.Content) > websocket.MaxMessageLength {
+		http.Error(w, "Message content is empty or too long", http.StatusBadRequest)
+		return
+	}
+
+	message, ok := getOwnChatMessage(w, user.ID, edit.ID)
+	if !ok {
+		return
+	}
+	if message.Content == edit.Content {
+		http.Error(w, "Message content is unchanged", http.StatusBadRequest)
+		return
+	}
+
+	editedAt := time.Now()
+	if err := query.EditChatMessage(message.ID, edit.Content, editedAt); err != nil {
+		http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		return
+	}
+	message.Content = edit.Content
+	message.EditedAt = &editedAt
+	websocket.SendChatUpdateToUsers(appCore.Hub, websocket.MessageTypeChatEdit, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		http.Error(w, "Failed to encode message", http.StatusInternalServerError)
+	}
+}
+
+func deleteChatMessage(appCore *middleware.AppCore, w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageId, err := strconv.Atoi(r.URL.Query().Get("messageId"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	message, ok := getDeletableChatMessage(w, user.ID, messageId)
+	if !ok {
+		return
+	}
+
+	deletedAt := time.Now()
+	fileNames, err := query.DeleteChatMessage(message.ID, deletedAt)
+	if err != nil {
+		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		return
+	}
+	for _, fileName := range fileNames {
+		if err := utilities.RemoveAttachment(fileName); err != nil {
+			log.Printf("Error deleting attachment file: %v", err)
+		}
+	}
+	message.Content = ""
+	message.DeletedAt = &deletedAt
+	message.Attachments = nil
+	websocket.SendChatUpdateToUsers(appCore.Hub, websocket.MessageTypeChatDelete, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		http.Error(w, "Failed to encode message", http.StatusInternalServerError)
+	}
+}
+
+// getDeletableChatMessage is getOwnChatMessage that also lets group admins load
+// other members' messages of their group chat.
+func getDeletableChatMessage(w http.ResponseWriter, userId int, messageId int) (models.ChatMessage, bool) {
+	message, err := query.GetChatMessage(messageId)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return models.ChatMessage{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return models.ChatMessage{}, false
+	}
+	if message.SenderID != userId {
+		admin := false
+		if message.GroupID != 0 {
+			admin, err = query.IsGroupAdmin(userId, message.GroupID)
+			if err != nil {
+				http.Error(w, "Failed to check group admin", http.StatusInternalServerError)
+				return models.ChatMessage{}, false
+			}
+		}
+		if !admin {
+			http.Error(w, "You can only delete your own messages", http.StatusForbidden)
+			return models.ChatMessage{}, false
+		}
+	}
+	if message.DeletedAt != nil {
+		http.Error(w, "Message has been deleted", http.StatusGone)
+		return models.ChatMessage{}, false
+	}
+	return message, true
+}
+
+// getOwnChatMessage loads a message the user sent and that is not deleted yet,
+// writing the error response and returning false otherwise.
+func getOwnChatMessage(w http.ResponseWriter, userId int, messageId int) (models.ChatMessage, bool) {
+	message, err := query.GetChatMessage(messageId)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return models.ChatMessage{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return models.ChatMessage{}, false
+	}
+	if message.SenderID != userId {
+		http.Error(w, "You can only change your own messages", http.StatusForbidden)
+		return models.ChatMessage{}, false
+	}
+	if message.DeletedAt != nil {
+		http.Error(w, "Message has been deleted", http.StatusGone)
+		return models.ChatMessage{}, false
+	}
+	return message, true
+}
+
+// GetMessageEditsHandler returns the previous versions of an edited message to the conversation's participants
+func GetMessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageId, err := strconv.Atoi(r.URL.Query().Get("messageId"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	message, err := query.GetChatMessage(messageId)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return
+	}
+	participant, err := query.IsChatParticipant(user.ID, message)
+	if err != nil {
+		http.Error(w, "Failed to check chat permissions", http.StatusInternalServerError)
+		return
+	}
+	if !participant {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	edits, err := query.GetMessageEdits(messageId)
+	if err != nil {
+		http.Error(w, "Failed to fetch message edits", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(edits); err != nil {
+		http.Error(w, "Failed to encode message edits", http.StatusInternalServerError)
+	}
+}
+
+// ReactToChatMessageHandler sets the user's reaction on a message of one of their
+// conversations, or removes it when sent again with the same type. The message with
+// its updated reactions is returned and pushed to every participant.
+func ReactToChatMessageHandler(appCore *middleware.AppCore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 		user, err := middleware.GetAuthenticatedUser(r)
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		var message models.ChatMessage
-		err = json.NewDecoder(r.Body).Decode(&message)
-		if err != nil {
+		var reaction struct {
+			MessageID      int `json:"messageId"`
+			ReactionTypeID int `json:"reactionTypeId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reaction); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		if _, err := query.GetReactionTypeByID(reaction.ReactionTypeID); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Unknown reaction type", http.StatusBadRequest)
+			} else {
+				http.Error(w, "Failed to check reaction type", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		message, err := query.GetChatMessage(reaction.MessageID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+			return
+		}
+		participant, err := query.IsChatParticipant(user.ID, message)
+		if err != nil {
+			http.Error(w, "Failed to check chat permissions", http.StatusInternalServerError)
+			return
+		}
+		if !participant {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if message.DeletedAt != nil {
+			http.Error(w, "Message has been deleted", http.StatusGone)
+			return
+		}
+
+		// Reacting is writing to the conversation, e.g. not possible once blocked
+		partnerId := message.SenderID
+		if partnerId == user.ID {
+			partnerId = message.ReceiverID
+		}
+		allowChat, err := query.CanSendChatMessage(user.ID, partnerId, message.GroupID)
+		if err != nil {
+			http.Error(w, "Failed to check chat permissions", http.StatusInternalServerError)
+			return
+		}
+		if !allowChat {
+			http.Error(w, "You are not allowed to message this conversation", http.StatusForbidden)
+			return
+		}
+
+		if _, err := query.ReactToChatMessage(message.ID, user.ID, reaction.ReactionTypeID); err != nil {
+			http.Error(w, "Failed to process reaction", http.StatusInternalServerError)
+			return
+		}
+		message, err = query.GetChatMessage(message.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+			return
+		}
+		websocket.SendChatUpdateToUsers(appCore.Hub, websocket.MessageTypeChatReaction, message)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(message); err != nil {
+			http.Error(w, "Failed to encode message", http.StatusInternalServerError)
+		}
+	}
+}
+
+// parseChatMessageForm reads a multipart chat message and saves its validated
+// attachments, writing the error response and returning false on failure.
+func parseChatMessageForm(w http.ResponseWriter, r *http.Request) (models.ChatMessage, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, utilities.MaxAttachments*utilities.MaxAttachmentSize+(1<<20))
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return models.ChatMessage{}, false
+	}
+
+	var message models.ChatMessage
+	message.SenderID, _ = strconv.Atoi(r.FormValue("senderId"))
+	message.ReceiverID, _ = strconv.Atoi(r.FormValue("receiverId"))
+	message.GroupID, _ = strconv.Atoi(r.FormValue("groupId"))
+	message.ReplyToID, _ = strconv.Atoi(r.FormValue("replyToId"))
+	message.Content = strings.TrimSpace(r.FormValue("content"))
+
+	headers := r.MultipartForm.File["attachments"]
+	if len(headers) > utilities.MaxAttachments {
+		http.Error(w, fmt.Sprintf("A message can have at most %d attachments", utilities.MaxAttachments), http.StatusBadRequest)
+		return models.ChatMessage{}, false
+	}
+	if message.Content == "" && len(headers) == 0 {
+		http.Error(w, "Message has no content or attachments", http.StatusBadRequest)
+		return models.ChatMessage{}, false
+	}
+	if len(message.Content) > websocket.MaxMessageLength {
+		http.Error(w, "Message content is too long", http.StatusBadRequest)
+		return models.ChatMessage{}, false
+	}
+
+	for _, header := range headers {
+		attachment, err := saveChatAttachment(header)
+		if err != nil {
+			for _, saved := range message.Attachments {
+				utilities.RemoveAttachment(saved.FileName)
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return models.ChatMessage{}, false
+		}
+		message.Attachments = append(message.Attachments, attachment)
+	}
+	return message, true
+}
+
+// saveChatAttachment validates one uploaded file and stores it with the other attachments
+func saveChatAttachment(header *multipart.FileHeader) (models.ChatAttachment, error) {
+	file, err := header.Open()
+	if err != nil {
+		return models.ChatAttachment{}, errors.New("Failed to read attachment")
+	}
+	defer file.Close()
+
+	contentType, err := utilities.ValidateAttachment(file, header)
+	if err != nil {
+		return models.ChatAttachment{}, err
+	}
+	fileName, err := utilities.SaveAttachment(file)
+	if err != nil {
+		log.Printf("Error saving attachment: %v", err)
+		return models.ChatAttachment{}, errors.New("Failed to save attachment")
+	}
+	return models.ChatAttachment{
+		FileName:    fileName,
+		Name:        filepath.Base(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+	}, nil
+}
+
+// GetChatAttachmentHandler sends an attachment to the participants of its conversation
+func GetChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	attachmentId, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+	attachment, err := query.GetChatAttachment(attachmentId)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch attachment", http.StatusInternalServerError)
+		return
+	}
+	message, err := query.GetChatMessage(attachment.MessageID)
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return
+	}
+	participant, err := query.IsChatParticipant(user.ID, message)
+	if err != nil {
+		http.Error(w, "Failed to check chat permissions", http.StatusInternalServerError)
+		return
+	}
+	if !participant {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(utilities.AttachmentsDir, attachment.FileName))
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	// Only images are shown inline, anything else is downloaded
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, attachment.Name, message.CreatedAt, file)
+}
+
+func SendMessageHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := middleware.GetAuthenticatedUser(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var message models.ChatMessage
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			// Messages with attachments are sent as a form, with the files under "attachments"
+			var ok bool
+			message, ok = parseChatMessageForm(w, r)
+			if !ok {
+				return
+			}
+			attachments := message.Attachments
+			defer func() {
+				// Files of a message that could not be stored are not kept
+				if message.ID == 0 {
+					for _, attachment := range attachments {
+						utilities.RemoveAttachment(attachment.FileName)
+					}
+				}
+			}()
+		} else {
+			err = json.NewDecoder(r.Body).Decode(&message)
+			if err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			message.Attachments = nil // Attachments can only be uploaded as files
+		}
+		message.CreatedAt = time.Now()
+		message.ReplyTo, message.Reactions = nil, nil
 		// Verification
 		if user.ID != message.SenderID {
 			http.Error(w, "Sender is not the same as the logged in User", http.StatusBadRequest)
@@ -142,14 +905,53 @@ func SendMessageHandler(appCore *middleware.AppCore) http.HandlerFunc {
 				http.Error(w, "Sender is not part of the group", http.StatusBadRequest)
 				return
 			}
+			allowChat, err := query.CanSendChatMessage(message.SenderID, 0, message.GroupID)
+			if err != nil {
+				http.Error(w, "Failed to check group chat settings", http.StatusInternalServerError)
+				return
+			}
+			if !allowChat {
+				http.Error(w, "Only group admins can post in this chat", http.StatusForbidden)
+				return
+			}
+			wait, err := query.GetSlowModeWait(message.SenderID, message.GroupID, message.CreatedAt)
+			if err != nil {
+				http.Error(w, "Failed to check group chat settings", http.StatusInternalServerError)
+				return
+			}
+			if wait > 0 {
+				seconds := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				http.Error(w, fmt.Sprintf("Slow mode is on, wait %d seconds before sending another message", seconds), http.StatusTooManyRequests)
+				return
+			}
 		} else {
-			allowChat, err := query.CheckIfUsersFollowsOrFollowed(message.SenderID, message.ReceiverID)
+			allowChat, err := query.CanSendChatMessage(message.SenderID, message.ReceiverID, 0)
 			if err != nil {
 				http.Error(w, "Failed to check user follower status", http.StatusInternalServerError)
 				return
 			}
 			if !allowChat {
-				http.Error(w, "Users do not have a follower relationship", http.StatusBadRequest)
+				// Without a follow relationship the message goes to the receiver's message requests
+				allowChat, err = query.StartMessageRequest(message.SenderID, message.ReceiverID)
+				if err != nil {
+					http.Error(w, "Failed to send message request", http.StatusInternalServerError)
+					return
+				}
+			}
+			if !allowChat {
+				http.Error(w, "You are not allowed to message this user", http.StatusForbidden)
+				return
+			}
+		}
+		if message.ReplyToID != 0 {
+			validReply, err := query.IsValidReply(message.ReplyToID, message)
+			if err != nil {
+				http.Error(w, "Failed to check the replied message", http.StatusInternalServerError)
+				return
+			}
+			if !validReply {
+				http.Error(w, "Can only reply to a message of the same conversation", http.StatusBadRequest)
 				return
 			}
 		}
@@ -196,37 +998,61 @@ func GetNewChatUsersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := middleware.GetAuthenticatedUser(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	userBName := r.URL.Query().Get("userBName")
-	groupId, err := strconv.Atoi(r.URL.Query().Get("groupId"))
-	if userBName == "" && (err != nil || groupId == 0) {
-		http.Error(w, "Invalid Username/groupId", http.StatusBadRequest)
-		return
-	}
-	if userBName != "" {
-		userBId, err := query.GetUserIdByUsername(userBName)
+// MarkMessageAsReadHandler marks a conversation as read up to the optional messageId,
+// or entirely without it, and pushes the read receipt to the other participants.
+func MarkMessageAsReadHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := middleware.GetAuthenticatedUser(r)
 		if err != nil {
-			http.Error(w, "Failed to find user", http.StatusInternalServerError)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
 
-		err = query.DeleteChatNotifications(user.ID, userBId)
-		if err != nil {
-			http.Error(w, "Failed to mark chat as read", http.StatusInternalServerError)
-			return
+		messageId := 0
+		if r.URL.Query().Get("messageId") != "" {
+			messageId, err = strconv.Atoi(r.URL.Query().Get("messageId"))
+			if err != nil || messageId < 0 {
+				http.Error(w, "Invalid message ID", http.StatusBadRequest)
+				return
+			}
 		}
-		w.WriteHeader(http.StatusOK)
-	} else {
-		err = query.DeleteGroupChatNotifications(user.ID, groupId)
-		if err != nil {
-			http.Error(w, "Failed to mark chat as read", http.StatusInternalServerError)
+
+		userBName := r.URL.Query().Get("userBName")
+		groupId, err := strconv.Atoi(r.URL.Query().Get("groupId"))
+		if userBName == "" && (err != nil || groupId == 0) {
+			http.Error(w, "Invalid Username/groupId", http.StatusBadRequest)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
+		if userBName != "" {
+			userBId, err := query.GetUserIdByUsername(userBName)
+			if err != nil {
+				http.Error(w, "Failed to find user", http.StatusInternalServerError)
+				return
+			}
+
+			err = websocket.MarkChatRead(appCore.Hub, user.ID, userBId, 0, messageId)
+			if err != nil {
+				http.Error(w, "Failed to mark chat as read", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		} else {
+			status, err := query.GetMemberStatus(user.ID, groupId)
+			if err != nil {
+				http.Error(w, "Failed to check user group member status", http.StatusInternalServerError)
+				return
+			}
+			if status != "accepted" {
+				http.Error(w, "User is not part of the group", http.StatusForbidden)
+				return
+			}
+
+			err = websocket.MarkChatRead(appCore.Hub, user.ID, 0, groupId, messageId)
+			if err != nil {
+				http.Error(w, "Failed to mark chat as read", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
 	}
 }
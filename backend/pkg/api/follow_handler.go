@@ -34,6 +34,18 @@ func InitFollowHandler(appCore *middleware.AppCore) http.HandlerFunc {
 			http.Error(w, "You cannot follow yourself", http.StatusBadRequest)
 			return
 		}
+		// Unfollowing stays possible, anything else is refused between blocked users
+		if followRequest.ButtonState != "Follow" {
+			blocked, err := query.IsBlocked(followRequest.FollowerID, followRequest.FollowedID)
+			if err != nil {
+				http.Error(w, "Failed to check block status", http.StatusInternalServerError)
+				return
+			}
+			if blocked {
+				http.Error(w, "You cannot follow this user", http.StatusForbidden)
+				return
+			}
+		}
 		followerUser, err := query.GetUserByID(followRequest.FollowerID)
 		if err != nil {
 			http.Error(w, "Failed to get follower details", http.StatusInternalServerError)
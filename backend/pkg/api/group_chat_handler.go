@@ -0,0 +1,231 @@
+package api
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
+	"backend/pkg/websocket"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Longest slow mode interval, and most messages pinned at once in a group chat
+const (
+	maxSlowModeSeconds = 3600
+	maxPinnedMessages  = 10
+)
+
+// GroupChatSettingsHandler returns the rules, admins and pinned messages of a group
+// chat to its members (GET ?groupId=), or lets an admin change the rules (POST).
+func GroupChatSettingsHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			groupId, err := strconv.Atoi(r.URL.Query().Get("groupId"))
+			if err != nil {
+				http.Error(w, "Invalid group ID", http.StatusBadRequest)
+				return
+			}
+			status, err := query.GetMemberStatus(user.ID, groupId)
+			if err != nil {
+				http.Error(w, "Failed to check user group member status", http.StatusInternalServerError)
+				return
+			}
+			if status != "accepted" {
+				http.Error(w, "You are not part of the group", http.StatusForbidden)
+				return
+			}
+			sendGroupChatSettings(w, nil, groupId)
+		case http.MethodPost:
+			var request struct {
+				GroupID          int  `json:"groupId"`
+				AnnouncementOnly bool `json:"announcementOnly"`
+				SlowModeSeconds  int  `json:"slowModeSeconds"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if request.SlowModeSeconds < 0 || request.SlowModeSeconds > maxSlowModeSeconds {
+				http.Error(w, "slowModeSeconds must be between 0 and 3600", http.StatusBadRequest)
+				return
+			}
+			if !requireGroupAdmin(w, user.ID, request.GroupID) {
+				return
+			}
+
+			if err := query.UpdateGroupChatSettings(request.GroupID, request.AnnouncementOnly, request.SlowModeSeconds); err != nil {
+				http.Error(w, "Failed to update group chat settings", http.StatusInternalServerError)
+				return
+			}
+			sendGroupChatSettings(w, appCore.Hub, request.GroupID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// GroupChatAdminsHandler lets the group creator make an accepted member an admin of
+// the group chat, or take it back with admin set to false.
+func GroupChatAdminsHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var request struct {
+			GroupID int  `json:"groupId"`
+			UserID  int  `json:"userId"`
+			Admin   bool `json:"admin"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		isCreator, err := query.IsCreator(request.GroupID, user.ID)
+		if err != nil {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		if !isCreator {
+			http.Error(w, "Only the group creator can choose admins", http.StatusForbidden)
+			return
+		}
+		if request.UserID == user.ID {
+			http.Error(w, "The group creator is always an admin", http.StatusBadRequest)
+			return
+		}
+
+		role := query.GroupRoleMember
+		if request.Admin {
+			role = query.GroupRoleAdmin
+		}
+		if err := query.SetGroupMemberRole(request.GroupID, request.UserID, role); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "User is not a member of the group", http.StatusBadRequest)
+			} else {
+				http.Error(w, "Failed to update group admins", http.StatusInternalServerError)
+			}
+			return
+		}
+		sendGroupChatSettings(w, appCore.Hub, request.GroupID)
+	}
+}
+
+// PinnedMessagesHandler lets a group admin pin (POST {messageId}) or unpin
+// (DELETE ?messageId=) a message of the group chat.
+func PinnedMessagesHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var messageId int
+		switch r.Method {
+		case http.MethodPost:
+			var request struct {
+				MessageID int `json:"messageId"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			messageId = request.MessageID
+		case http.MethodDelete:
+			messageId, err = strconv.Atoi(r.URL.Query().Get("messageId"))
+			if err != nil {
+				http.Error(w, "Invalid message ID", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		message, err := query.GetChatMessage(messageId)
+		if err == sql.ErrNoRows || (err == nil && message.GroupID == 0) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+			return
+		}
+		if !requireGroupAdmin(w, user.ID, message.GroupID) {
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if err := query.UnpinChatMessage(message.GroupID, message.ID); err != nil {
+				http.Error(w, "Failed to unpin message", http.StatusInternalServerError)
+				return
+			}
+			sendGroupChatSettings(w, appCore.Hub, message.GroupID)
+			return
+		}
+
+		if message.DeletedAt != nil {
+			http.Error(w, "Message has been deleted", http.StatusGone)
+			return
+		}
+		pinned, err := query.CountPinnedMessages(message.GroupID)
+		if err != nil {
+			http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+			return
+		}
+		if pinned >= maxPinnedMessages {
+			http.Error(w, "Too many pinned messages, unpin one first", http.StatusConflict)
+			return
+		}
+		if err := query.PinChatMessage(message.GroupID, message.ID, user.ID); err != nil {
+			http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+			return
+		}
+		sendGroupChatSettings(w, appCore.Hub, message.GroupID)
+	}
+}
+
+// requireGroupAdmin checks that the user is an admin of the group, writing the error
+// response and returning false otherwise.
+func requireGroupAdmin(w http.ResponseWriter, userId int, groupId int) bool {
+	admin, err := query.IsGroupAdmin(userId, groupId)
+	if err != nil {
+		http.Error(w, "Failed to check group admin", http.StatusInternalServerError)
+		return false
+	}
+	if !admin {
+		http.Error(w, "Only group admins can do this", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// sendGroupChatSettings responds with the current settings of the group chat, and
+// pushes them to every member after a change when hub is set.
+func sendGroupChatSettings(w http.ResponseWriter, hub *websocket.Hub, groupId int) {
+	settings, err := query.GetGroupChatSettings(groupId)
+	if err != nil {
+		http.Error(w, "Failed to fetch group chat settings", http.StatusInternalServerError)
+		return
+	}
+	if hub != nil {
+		websocket.SendGroupChatSettingsToMembers(hub, settings)
+	}
+	sendJSONResponse(w, settings)
+}
@@ -54,6 +54,16 @@ func AddCommentHandler(appCore *middleware.AppCore) http.HandlerFunc {
 			return
 		}
 
+		permitted, err := query.IsUserPermittedToViewPost(postIDInt, user.ID)
+		if err != nil {
+			http.Error(w, "Error checking post permissions", http.StatusInternalServerError)
+			return
+		}
+		if !permitted {
+			http.Error(w, "You do not have permission to comment on this post", http.StatusForbidden)
+			return
+		}
+
 		comment := models.Comment{
 			PostID: postIDInt,
 			User: models.SafeUser{
@@ -165,7 +175,13 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comments, err := query.GetCommentsQuery(postID)
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comments, err := query.GetCommentsQuery(postID, user.ID)
 	if err != nil {
 		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
 		return
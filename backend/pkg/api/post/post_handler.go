@@ -372,7 +372,7 @@ func GetSinglePostHandler(w http.ResponseWriter, r *http.Request) {
 		post.UserReaction = userReaction
 	}
 
-	comments, err := query.GetCommentsQuery(postID)
+	comments, err := query.GetCommentsQuery(postID, user.ID)
 	if err != nil {
 		log.Printf("Error retrieving comments: %v", err)
 		http.Error(w, "Error retrieving comments", http.StatusInternalServerError)
@@ -542,13 +542,19 @@ func GetGroupPostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := middleware.GetAuthenticatedUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	groupID, err := query.GetGroupIDByName(groupname)
 	if err != nil {
 		http.Error(w, "Invalid group name", http.StatusBadRequest)
 		return
 	}
 
-	posts, err := query.GetGroupPostsQuery(groupID)
+	posts, err := query.GetGroupPostsQuery(groupID, user.ID)
 	if err != nil {
 		http.Error(w, "Error retrieving group posts", http.StatusInternalServerError)
 		return
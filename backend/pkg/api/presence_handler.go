@@ -0,0 +1,111 @@
+package api
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
+	"backend/pkg/models"
+	"backend/pkg/websocket"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxPresenceIDs limits how many users can be looked up in one presence request
+const maxPresenceIDs = 100
+
+// GetPresenceHandler returns the online status and last seen time of the users in ?ids=1,2,3.
+// Users who hide their status, or who are not related to the requester, are reported offline.
+func GetPresenceHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		idList := strings.Split(r.URL.Query().Get("ids"), ",")
+		if len(idList) > maxPresenceIDs {
+			http.Error(w, "Too many user IDs", http.StatusBadRequest)
+			return
+		}
+
+		presences := []models.Presence{}
+		for _, idStr := range idList {
+			userID, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil {
+				http.Error(w, "Invalid user ID", http.StatusBadRequest)
+				return
+			}
+
+			allowed, err := query.CanSeePresence(user.ID, userID)
+			if err != nil {
+				http.Error(w, "Failed to check presence visibility", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				presences = append(presences, models.Presence{UserID: userID})
+				continue
+			}
+
+			presence, err := websocket.GetUserPresence(appCore.Hub, userID)
+			if err != nil {
+				http.Error(w, "Failed to fetch presence", http.StatusInternalServerError)
+				return
+			}
+			presences = append(presences, presence)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(presences); err != nil {
+			http.Error(w, "Failed to encode presence", http.StatusInternalServerError)
+		}
+	}
+}
+
+// PresenceSettingsHandler reads (GET) or changes (POST) whether the user shares their online status
+func PresenceSettingsHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			_, showPresence, err := query.GetLastSeen(user.ID)
+			if err != nil {
+				http.Error(w, "Failed to fetch presence settings", http.StatusInternalServerError)
+				return
+			}
+			sendJSONResponse(w, map[string]bool{"showPresence": showPresence})
+		case http.MethodPost:
+			var settings struct {
+				ShowPresence bool `json:"showPresence"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			changed, err := query.SetShowPresence(user.ID, settings.ShowPresence)
+			if err != nil {
+				http.Error(w, "Failed to update presence settings", http.StatusInternalServerError)
+				return
+			}
+
+			// Followers see the user go offline when hiding, or their real status when showing again
+			if changed {
+				websocket.BroadcastPresence(appCore.Hub, user.ID)
+			}
+			sendJSONResponse(w, map[string]bool{"showPresence": settings.ShowPresence})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
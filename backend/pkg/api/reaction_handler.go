@@ -40,6 +40,27 @@ func ReactHandler(appCore *middleware.AppCore) http.HandlerFunc {
 		}
 		reaction.UserID = user.ID
 
+		// Users blocked from a post cannot react to it or to its comments
+		postID := 0
+		if reaction.PostID != nil {
+			postID = *reaction.PostID
+		} else {
+			comment, err := query.GetCommentByID(*reaction.CommentID)
+			if err != nil {
+				http.Error(w, "Comment not found", http.StatusNotFound)
+				return
+			}
+			postID = comment.PostID
+		}
+		permitted, err := query.IsUserPermittedToViewPost(postID, user.ID)
+		if err != nil {
+			http.Error(w, "Error checking post permissions", http.StatusInternalServerError)
+			return
+		}
+		if !permitted {
+			http.Error(w, "You do not have permission to react to this post", http.StatusForbidden)
+			return
+		}
 
 		// Add, update, or remove the reaction
 		exists, err := query.AddOrUpdateReaction(reaction)
@@ -0,0 +1,232 @@
+package api
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
+	"backend/pkg/models"
+	"backend/pkg/utilities"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared against when a login names no account, so that it
+// takes as long as a wrong password and does not reveal which accounts exist
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// accountThrottleKey identifies an account in the login throttle, the same whether
+// it was logged into by username or email
+func accountThrottleKey(userID int) string {
+	return fmt.Sprintf("account:%d", userID)
+}
+
+// loginThrottleKey identifies what a login attempt counts against. Names of accounts
+// that do not exist are throttled the same way as real ones.
+func loginThrottleKey(user models.User, found bool, identifier string) string {
+	if found {
+		return accountThrottleKey(user.ID)
+	}
+	return "login:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+func loginIPThrottleKey(ip string) string {
+	return "login-ip:" + ip
+}
+
+func signupIPThrottleKey(ip string) string {
+	return "signup-ip:" + ip
+}
+
+func emailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func emailIPThrottleKey(ip string) string {
+	return "email-ip:" + ip
+}
+
+// securityEvent describes an event of the request for the security log
+func securityEvent(r *http.Request, eventType string, userID int, identifier string, details string) models.SecurityEvent {
+	return models.SecurityEvent{
+		UserID:     userID,
+		Type:       eventType,
+		Identifier: identifier,
+		IPAddress:  utilities.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+		Details:    details,
+	}
+}
+
+// loginAttempts are what an attempt to prove who one is counts against: the account
+// and the IP address
+func loginAttempts(r *http.Request, accountKey string) []query.Attempt {
+	return []query.Attempt{
+		{Key: accountKey, Throttle: query.AccountLoginThrottle},
+		{Key: loginIPThrottleKey(utilities.ClientIP(r)), Throttle: query.IPLoginThrottle},
+	}
+}
+
+// takeAttempts counts the request against the throttled keys before anything is
+// checked, and refuses it when any of them is locked, telling the client how long to
+// wait. It writes the error response and returns false when refused. Otherwise it
+// returns until when each key is now locked, the zero time for those that are not.
+func takeAttempts(w http.ResponseWriter, attempts ...query.Attempt) ([]time.Time, bool) {
+	lockedUntil, lockouts, err := query.TakeAttempts(attempts...)
+	if err != nil {
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		return nil, false
+	}
+	if lockedUntil.IsZero() {
+		return lockouts, true
+	}
+
+	wait := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(wait))
+	sendErrorResponse(w, fmt.Sprintf("Too many failed attempts, try again in %d seconds", wait), http.StatusTooManyRequests)
+	return nil, false
+}
+
+// refundAttempts takes back the attempts of a login that succeeded, only failures count
+func refundAttempts(attempts []query.Attempt, lockouts []time.Time) {
+	for i, attempt := range attempts {
+		// Errors are logged by the query, the attempt then counts as a failure
+		query.RefundAttempt(attempt.Key, lockouts[i])
+	}
+}
+
+// recordLoginFailure logs a wrong password or code, counted by takeAttempts against
+// the account and the IP address, and the lockouts it caused
+func recordLoginFailure(r *http.Request, eventType string, userID int, identifier string, lockouts []time.Time) {
+	query.LogSecurityEvent(securityEvent(r, eventType, userID, identifier, ""))
+
+	if until := lockouts[0]; !until.IsZero() {
+		query.LogSecurityEvent(securityEvent(r, query.EventAccountLocked, userID, identifier,
+			"locked until "+until.Format(time.RFC3339)))
+	}
+	if until := lockouts[1]; !until.IsZero() {
+		query.LogSecurityEvent(securityEvent(r, query.EventIPLocked, 0, "",
+			"locked until "+until.Format(time.RFC3339)))
+	}
+}
+
+// throttleEmail refuses to send another account email to the address, or to the
+// client, when they asked for too many. Every request counts, sent or not, so
+// the mailbox of someone else cannot be flooded. It writes the error response
+// and returns false when refused.
+func throttleEmail(w http.ResponseWriter, r *http.Request, userID int, email string) bool {
+	lockouts, ok := takeAttempts(w,
+		query.Attempt{Key: emailThrottleKey(email), Throttle: query.EmailThrottle},
+		query.Attempt{Key: emailIPThrottleKey(utilities.ClientIP(r)), Throttle: query.IPEmailThrottle})
+	if !ok {
+		return false
+	}
+
+	if until := lockouts[0]; !until.IsZero() {
+		query.LogSecurityEvent(securityEvent(r, query.EventEmailLocked, userID, email,
+			"locked until "+until.Format(time.RFC3339)))
+	}
+	if until := lockouts[1]; !until.IsZero() {
+		query.LogSecurityEvent(securityEvent(r, query.EventEmailLocked, userID, "",
+			"IP address locked until "+until.Format(time.RFC3339)))
+	}
+	return true
+}
+
+// SecurityEventsHandler returns the latest entries of the security log (GET), those
+// of one user with ?userId=, at most ?limit= of them
+func SecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := 0
+	if value := r.URL.Query().Get("userId"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = id
+	}
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 500 {
+			http.Error(w, "Limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	events, err := query.GetSecurityEvents(userID, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch security events", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, events)
+}
+
+// UnlockHandler lifts the login lockout of an account, named by username or email,
+// and/or the login and signup lockouts of an IP address
+func UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		User string `json:"user"`
+		IP   string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.User = strings.TrimSpace(request.User)
+	request.IP = strings.TrimSpace(request.IP)
+	if request.User == "" && request.IP == "" {
+		http.Error(w, "A user or an IP address is required", http.StatusBadRequest)
+		return
+	}
+
+	if request.User != "" {
+		user, err := query.GetUserByEmailOrUsername(request.User)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "User not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to get user", http.StatusInternalServerError)
+			}
+			return
+		}
+		if err := query.ClearAttempts(accountThrottleKey(user.ID)); err != nil {
+			http.Error(w, "Failed to unlock account", http.StatusInternalServerError)
+			return
+		}
+		query.LogSecurityEvent(securityEvent(r, query.EventAccountUnlocked, user.ID, "",
+			"unlocked by "+admin.Username))
+	}
+	if request.IP != "" {
+		if err := query.ClearAttempts(loginIPThrottleKey(request.IP), signupIPThrottleKey(request.IP), emailIPThrottleKey(request.IP)); err != nil {
+			http.Error(w, "Failed to unlock IP address", http.StatusInternalServerError)
+			return
+		}
+		query.LogSecurityEvent(securityEvent(r, query.EventAccountUnlocked, 0, "",
+			"IP address "+request.IP+" unlocked by "+admin.Username))
+	}
+
+	sendJSONResponse(w, map[string]string{"message": "Unlocked"})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
+	"backend/pkg/websocket"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// SessionsHandler lists the devices the user is logged in on (GET), or logs one of
+// them out (DELETE ?id=), closing its real-time connections. Revoking the current
+// session is the same as logging out.
+func SessionsHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			sessions, err := query.GetUserSessions(user.ID, cookie.Value)
+			if err != nil {
+				http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+				return
+			}
+			sendJSONResponse(w, sessions)
+		case http.MethodDelete:
+			publicID := r.URL.Query().Get("id")
+			if publicID == "" {
+				http.Error(w, "Session ID is required", http.StatusBadRequest)
+				return
+			}
+			sessionID, err := query.RevokeSession(user.ID, publicID)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, "Session not found", http.StatusNotFound)
+				} else {
+					http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+				}
+				return
+			}
+			websocket.DisconnectSession(appCore.Hub, sessionID)
+
+			if sessionID == cookie.Value {
+				http.SetCookie(w, &http.Cookie{
+					Name:     "session_id",
+					Value:    "",
+					Expires:  time.Now().Add(-1 * time.Hour),
+					HttpOnly: true,
+					Secure:   true,
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// RevokeOtherSessionsHandler logs the user out of every device but the one making
+// the request, and closes the real-time connections of those sessions.
+func RevokeOtherSessionsHandler(appCore *middleware.AppCore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, err := middleware.GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessionIDs, err := query.RevokeOtherSessions(user.ID, cookie.Value)
+		if err != nil {
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		for _, sessionID := range sessionIDs {
+			websocket.DisconnectSession(appCore.Hub, sessionID)
+		}
+		sendJSONResponse(w, map[string]int{"revoked": len(sessionIDs)})
+	}
+}
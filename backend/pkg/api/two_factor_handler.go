@@ -0,0 +1,318 @@
+package api
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/middleware"
+	"backend/pkg/utilities"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// totpIssuer names the account in authenticator apps
+const totpIssuer = "Social Network"
+
+// verifySecondFactor checks a code from the user's authenticator app, or else one of
+// their recovery codes, which is used up. A TOTP code is only accepted once.
+func verifySecondFactor(userID int, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if code == "" {
+		return false, nil
+	}
+
+	if len(code) == 6 && strings.Trim(code, "0123456789") == "" {
+		secret, enabled, err := query.GetTOTPSecret(userID)
+		if err != nil || !enabled {
+			return false, err
+		}
+		step, ok := utilities.ValidateTOTP(secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return query.UseTOTPStep(userID, step)
+	}
+	return query.UseRecoveryCode(userID, code)
+}
+
+// reauthenticate checks the password and second factor of a logged in user before
+// changing their 2FA settings, so a stolen session is not enough to turn it off.
+// It writes the error response and returns false when they do not match.
+// Wrong answers count towards the lockout of the account like failed logins.
+func reauthenticate(w http.ResponseWriter, r *http.Request, username string, password string, code string) bool {
+	account, err := query.GetUserByEmailOrUsername(username)
+	if err != nil {
+		http.Error(w, "Failed to check password", http.StatusInternalServerError)
+		return false
+	}
+	attempts := loginAttempts(r, accountThrottleKey(account.ID))
+	lockouts, ok := takeAttempts(w, attempts...)
+	if !ok {
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(password)) != nil {
+		recordLoginFailure(r, query.EventLoginFailed, account.ID, "", lockouts)
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return false
+	}
+
+	valid, err := verifySecondFactor(account.ID, code)
+	if err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return false
+	}
+	if !valid {
+		recordLoginFailure(r, query.EventTwoFactorFailed, account.ID, "", lockouts)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return false
+	}
+	refundAttempts(attempts, lockouts)
+	return true
+}
+
+// LoginTwoFactorHandler completes the login of a user with 2FA, with the token from
+// the password step and a code from their authenticator app or a recovery code
+func LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	userID, rememberMe, err := query.AttemptLoginChallenge(request.Token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendErrorResponse(w, "Login expired, enter your password again", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Failed to check login", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	attempts := loginAttempts(r, accountThrottleKey(userID))
+	lockouts, ok := takeAttempts(w, attempts...)
+	if !ok {
+		return
+	}
+
+	valid, err := verifySecondFactor(userID, request.Code)
+	if err != nil {
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		recordLoginFailure(r, query.EventTwoFactorFailed, userID, "", lockouts)
+		sendErrorResponse(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	refundAttempts(attempts, lockouts)
+	query.DeleteLoginChallenge(request.Token)
+
+	account, err := query.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	user, err := query.GetUserByEmailOrUsername(account.Username)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	completeLogin(w, r, user, rememberMe)
+}
+
+// TwoFactorHandler tells whether the logged in user has 2FA on (GET), and how many
+// recovery codes they have left
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	recoveryCodes, err := query.CountRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, map[string]interface{}{
+		"enabled":       user.TwoFactor,
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+// TwoFactorSetupHandler starts turning on 2FA: it generates a new secret and returns
+// it with the otpauth:// URI to show as a QR code. 2FA is only on once
+// EnableTwoFactorHandler receives a code made with the secret.
+func TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if user.TwoFactor {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := utilities.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if err := query.SetPendingTOTPSecret(user.ID, secret); err != nil {
+		http.Error(w, "Failed to start two-factor setup", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, map[string]string{
+		"secret": secret,
+		"uri":    utilities.TOTPProvisioningURI(totpIssuer, user.Username, secret),
+	})
+}
+
+// EnableTwoFactorHandler turns on 2FA once the user proves their authenticator app
+// has the secret, and returns the recovery codes, which are only shown this once
+func EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secret, enabled, err := query.GetTOTPSecret(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if secret == "" {
+		http.Error(w, "Start the two-factor setup first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := utilities.ValidateTOTP(secret, strings.TrimSpace(request.Code), time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	codes, err := query.EnableTOTP(user.ID, step)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		}
+		return
+	}
+	query.LogSecurityEvent(securityEvent(r, query.EventTwoFactorEnabled, user.ID, "", ""))
+	sendJSONResponse(w, map[string][]string{"recoveryCodes": codes})
+}
+
+// DisableTwoFactorHandler turns off 2FA after the user enters their password and a
+// current code or recovery code again
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !user.TwoFactor {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !reauthenticate(w, r, user.Username, request.Password, request.Code) {
+		return
+	}
+
+	if err := query.DisableTOTP(user.ID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	query.LogSecurityEvent(securityEvent(r, query.EventTwoFactorDisabled, user.ID, "", ""))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RecoveryCodesHandler replaces the recovery codes of the user, after they enter
+// their password and a current code again, and returns the new ones
+func RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := middleware.GetUserFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !user.TwoFactor {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !reauthenticate(w, r, user.Username, request.Password, request.Code) {
+		return
+	}
+
+	codes, err := query.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	query.LogSecurityEvent(securityEvent(r, query.EventRecoveryCodesRenewed, user.ID, "", ""))
+	sendJSONResponse(w, map[string][]string{"recoveryCodes": codes})
+}
@@ -116,7 +116,8 @@ func GetUserHandler(w http.ResponseWriter, r *http.Request) {
 func GetImageHandler(w http.ResponseWriter, r *http.Request) {
 	imageName := r.URL.Query().Get("imageName") // Get the image name from the query parameter
 
-	imagePath := filepath.Join("../../pkg/db/uploads", imageName) 
+	// Only the file name is kept so the path cannot leave uploads, e.g. into the chat attachments
+	imagePath := filepath.Join("../../pkg/db/uploads", filepath.Base(imageName))
 
 	// Check if the file exists
 	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
@@ -149,8 +150,16 @@ func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	updatedUser.AboutMe = r.FormValue("aboutMe")
 	updatedUser.Password = r.FormValue("password")
 	updatedUser.IsPublic = r.FormValue("isPublic") == "true"
+	updatedUser.MessageRequests = r.FormValue("messageRequests") // Kept as is when not sent
 	updatedUser.ID = user.ID // Ensure the user ID is not changed
 
+	switch updatedUser.MessageRequests {
+	case "", query.MessageRequestsAnyone, query.MessageRequestsFollowers, query.MessageRequestsNobody:
+	default:
+		http.Error(w, "messageRequests must be anyone, followers or nobody", http.StatusBadRequest)
+		return
+	}
+
 	isAvatarDeleted := r.FormValue("isAvatarDeleted") == "true"
 
 	// Handle file upload or deletion
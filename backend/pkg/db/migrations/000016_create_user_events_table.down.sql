@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_events;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN last_seen_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN show_presence BOOLEAN NOT NULL DEFAULT TRUE;
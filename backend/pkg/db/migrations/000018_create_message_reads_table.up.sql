@@ -0,0 +1,22 @@
+CREATE TABLE message_reads (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    read_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, user_id)
+);
+
+-- Messages with no pending chat notification for a recipient were already read by them
+INSERT INTO message_reads (message_id, user_id, read_at)
+SELECT m.id, m.receiver_id, m.created_at FROM messages m
+WHERE m.receiver_id IS NOT NULL AND NOT EXISTS (
+    SELECT 1 FROM chat_notifications n WHERE n.message_id = m.id AND n.notifiedUser_id = m.receiver_id
+);
+
+INSERT INTO message_reads (message_id, user_id, read_at)
+SELECT m.id, gm.user_id, m.created_at FROM messages m
+JOIN group_members gm ON gm.group_id = m.group_id AND gm.status = 'accepted' AND gm.user_id != m.sender_id AND gm.created_at <= m.created_at
+WHERE NOT EXISTS (
+    SELECT 1 FROM chat_notifications n WHERE n.message_id = m.id AND n.notifiedUser_id = gm.user_id
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS message_attachments;
\ No newline at end of file
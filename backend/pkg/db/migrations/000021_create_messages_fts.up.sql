@@ -0,0 +1,4 @@
+-- The full-text index of messages needs SQLite built with FTS5, which not every
+-- build of the server has. The server sets it up after the migrations when it can,
+-- see sqlite.SetUpMessageSearch.
+SELECT 1;
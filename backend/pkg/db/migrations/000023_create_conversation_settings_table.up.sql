@@ -0,0 +1,10 @@
+CREATE TABLE conversation_settings (
+    user_id INTEGER NOT NULL,
+    partner_id INTEGER NOT NULL DEFAULT 0, -- Other user of a private chat, 0 for a group chat
+    group_id INTEGER NOT NULL DEFAULT 0,   -- 0 for a private chat
+    muted BOOLEAN NOT NULL DEFAULT FALSE,
+    muted_until DATETIME,                  -- NULL keeps the conversation muted until unmuted
+    archived_message_id INTEGER,           -- Archived until a message newer than this one arrives
+    PRIMARY KEY (user_id, partner_id, group_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_reactions;
+ALTER TABLE messages DROP COLUMN reply_to_id;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS pinned_messages;
+DROP TABLE IF EXISTS group_chat_settings;
+ALTER TABLE group_members DROP COLUMN role;
@@ -0,0 +1,20 @@
+ALTER TABLE group_members ADD COLUMN role TEXT CHECK(role IN ('member', 'admin')) NOT NULL DEFAULT 'member';
+
+CREATE TABLE group_chat_settings (
+    group_id INTEGER PRIMARY KEY,
+    announcement_only BOOLEAN NOT NULL DEFAULT FALSE,
+    slow_mode_seconds INTEGER NOT NULL DEFAULT 0 CHECK (slow_mode_seconds >= 0),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE
+);
+
+CREATE TABLE pinned_messages (
+    group_id INTEGER NOT NULL,
+    message_id INTEGER NOT NULL,
+    pinned_by INTEGER NOT NULL,
+    pinned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, message_id),
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (pinned_by) REFERENCES users(id) ON DELETE CASCADE
+);
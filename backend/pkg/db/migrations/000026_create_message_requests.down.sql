@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_requests;
+ALTER TABLE users DROP COLUMN message_requests;
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN message_requests TEXT CHECK(message_requests IN ('anyone', 'followers', 'nobody')) NOT NULL DEFAULT 'anyone';
+
+CREATE TABLE message_requests (
+    sender_id INTEGER NOT NULL,
+    receiver_id INTEGER NOT NULL,
+    status TEXT CHECK(status IN ('pending', 'accepted', 'declined')) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (sender_id, receiver_id),
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE,
+    CHECK (sender_id != receiver_id)
+);
+
+CREATE INDEX idx_message_requests_receiver_id ON message_requests(receiver_id, status);
@@ -0,0 +1,9 @@
+ALTER TABLE sessions ADD COLUMN public_id TEXT;
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN last_used_at TIMESTAMP;
+
+UPDATE sessions SET public_id = lower(hex(randomblob(16))), last_used_at = created_at;
+
+CREATE UNIQUE INDEX idx_sessions_public_id ON sessions(public_id);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
@@ -0,0 +1,4 @@
+ALTER TABLE sessions ADD COLUMN absolute_expires_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN remember_me BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE sessions SET absolute_expires_at = expires_at;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS security_events;
+DROP TABLE IF EXISTS auth_throttles;
+ALTER TABLE users DROP COLUMN is_admin;
@@ -0,0 +1,25 @@
+-- Site administrators, who can read the security log and unlock accounts
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Attempts counted towards a lockout, keyed by what is limited: an account, an IP address...
+CREATE TABLE auth_throttles (
+    key TEXT PRIMARY KEY,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_attempt_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+CREATE TABLE security_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    event_type TEXT NOT NULL,
+    identifier TEXT,
+    ip_address TEXT,
+    user_agent TEXT,
+    details TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_security_events_user_id ON security_events(user_id, created_at);
+CREATE INDEX idx_security_events_created_at ON security_events(created_at);
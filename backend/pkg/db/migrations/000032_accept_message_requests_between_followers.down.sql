@@ -0,0 +1,2 @@
+-- Accepted requests cannot be told apart from the ones accepted by the user
+SELECT 1;
@@ -0,0 +1,8 @@
+-- Users who follow each other can chat, requests between them no longer hold messages back
+UPDATE message_requests SET status = 'accepted', updated_at = CURRENT_TIMESTAMP
+WHERE status != 'accepted' AND EXISTS (
+    SELECT 1 FROM followers f
+    WHERE f.status = 'accepted'
+        AND ((f.follower_id = message_requests.sender_id AND f.followed_id = message_requests.receiver_id)
+            OR (f.follower_id = message_requests.receiver_id AND f.followed_id = message_requests.sender_id))
+);
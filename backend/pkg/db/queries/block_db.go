@@ -0,0 +1,127 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"fmt"
+	"log"
+)
+
+// Kinds of restriction a user can put on another user
+const (
+	BlockTypeBlock = "block" // Both users are hidden from each other and cannot interact
+	BlockTypeMute  = "mute"  // The muted user's content is hidden from the muter's feeds only
+)
+
+// hiddenUserCondition is an SQL condition on a user ID column, true when the viewer
+// blocked or muted that user, or was blocked by them. It takes the viewer ID twice.
+func hiddenUserCondition(column string) string {
+	return fmt.Sprintf(`EXISTS (
+		SELECT 1 FROM blocks
+		WHERE (blocker_id = ? AND blocked_id = %[1]s) OR (blocker_id = %[1]s AND blocked_id = ? AND type = 'block')
+	)`, column)
+}
+
+// blockedUserCondition is like hiddenUserCondition, but ignores mutes.
+func blockedUserCondition(column string) string {
+	return fmt.Sprintf(`EXISTS (
+		SELECT 1 FROM blocks
+		WHERE type = 'block' AND ((blocker_id = ? AND blocked_id = %[1]s) OR (blocker_id = %[1]s AND blocked_id = ?))
+	)`, column)
+}
+
+// BlockUser blocks or mutes blockedID for blockerID, replacing any previous restriction.
+// A block also ends the follow relationships and requests between the two users and
+// removes the notifications they sent each other.
+func BlockUser(blockerID int, blockedID int, blockType string) error {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO blocks (blocker_id, blocked_id, type) VALUES (?, ?, ?)
+		ON CONFLICT(blocker_id, blocked_id) DO UPDATE SET type = excluded.type, created_at = CURRENT_TIMESTAMP
+	`, blockerID, blockedID, blockType)
+	if err != nil {
+		log.Printf("Error blocking user: %v", err)
+		return err
+	}
+
+	if blockType == BlockTypeBlock {
+		_, err = tx.Exec(`
+			DELETE FROM followers
+			WHERE (follower_id = ? AND followed_id = ?) OR (follower_id = ? AND followed_id = ?)
+		`, blockerID, blockedID, blockedID, blockerID)
+		if err != nil {
+			log.Printf("Error removing follows of blocked user: %v", err)
+			return err
+		}
+
+		_, err = tx.Exec(`
+			DELETE FROM notifications
+			WHERE (notifyingUser_id = ? AND notifiedUser_id = ?) OR (notifyingUser_id = ? AND notifiedUser_id = ?)
+		`, blockerID, blockedID, blockedID, blockerID)
+		if err != nil {
+			log.Printf("Error removing notifications of blocked user: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UnblockUser lifts the block or mute blockerID put on blockedID
+func UnblockUser(blockerID int, blockedID int) error {
+	_, err := sqlite.DB.Exec("DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID)
+	if err != nil {
+		log.Printf("Error unblocking user: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetBlockedUsers returns the users blockerID blocked or muted, most recent first
+func GetBlockedUsers(blockerID int) ([]models.BlockedUser, error) {
+	rows, err := sqlite.DB.Query(`
+		SELECT u.id, u.username, u.avatar_url, b.type, b.created_at
+		FROM blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = ?
+		ORDER BY b.created_at DESC
+	`, blockerID)
+	if err != nil {
+		log.Printf("Error retrieving blocked users: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := []models.BlockedUser{}
+	for rows.Next() {
+		var b models.BlockedUser
+		if err := rows.Scan(&b.User.ID, &b.User.Username, &b.User.ProfileImg, &b.Type, &b.CreatedAt); err != nil {
+			log.Printf("Error scanning blocked user row: %v", err)
+			return nil, err
+		}
+		blocked = append(blocked, b)
+	}
+	return blocked, rows.Err()
+}
+
+// IsBlocked reports whether either user blocked the other. Mutes are ignored.
+func IsBlocked(userA int, userB int) (bool, error) {
+	var blocked bool
+	err := sqlite.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE type = 'block' AND ((blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?))
+		)
+	`, userA, userB, userB, userA).Scan(&blocked)
+	if err != nil {
+		log.Printf("Error checking block: %v", err)
+		return false, err
+	}
+	return blocked, nil
+}
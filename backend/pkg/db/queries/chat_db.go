@@ -3,19 +3,30 @@ package query
 import (
 	"backend/pkg/db/sqlite"
 	"backend/pkg/models"
+	"database/sql"
 	"golang.org/x/exp/slices"
 	"fmt"
 	"log"
 	//"slices"
 	"strconv"
+	"time"
 )
 
+// CreateChatMessage stores a message together with its attachments, whose files
+// must already be saved
 func CreateChatMessage(chatMessage models.ChatMessage) (models.ChatMessage, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return models.ChatMessage{}, err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO messages (sender_id, receiver_id, group_id, content, created_at)
-		VALUES (?, NULLIF(?, 0), NULLIF(?, 0), ?, ?);
+		INSERT INTO messages (sender_id, receiver_id, group_id, content, created_at, reply_to_id)
+		VALUES (?, NULLIF(?, 0), NULLIF(?, 0), ?, ?, NULLIF(?, 0));
 	`
-	result, err := sqlite.DB.Exec(query, chatMessage.SenderID, chatMessage.ReceiverID, chatMessage.GroupID, chatMessage.Content, chatMessage.CreatedAt)
+	result, err := tx.Exec(query, chatMessage.SenderID, chatMessage.ReceiverID, chatMessage.GroupID, chatMessage.Content, chatMessage.CreatedAt, chatMessage.ReplyToID)
 	if err != nil {
 		log.Printf("Error inserting message: %v\n%v", err, chatMessage)
 		return models.ChatMessage{}, err
@@ -26,7 +37,20 @@ func CreateChatMessage(chatMessage models.ChatMessage) (models.ChatMessage, erro
 		return models.ChatMessage{}, err
 	}
 	chatMessage.ID = int(chatID)
-	return chatMessage, nil
+
+	if err := insertChatAttachments(tx, chatMessage.ID, chatMessage.Attachments); err != nil {
+		return models.ChatMessage{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing message: %v", err)
+		return models.ChatMessage{}, err
+	}
+
+	messages := []models.ChatMessage{chatMessage}
+	if err := addReplyPreviews(messages); err != nil {
+		return models.ChatMessage{}, err
+	}
+	return messages[0], nil
 }
 
 func CreateChatNotifications(messageId int, notifiedUserIds []int) error {
@@ -44,7 +68,7 @@ func CreateChatNotifications(messageId int, notifiedUserIds []int) error {
 	_, err := sqlite.DB.Exec(query)
 
 	if err != nil {
-		log.Printf("Error inserting message: %v\n%v", err, messageId, notifiedUserIds)
+		log.Printf("Error inserting message: %v\n%v %v", err, messageId, notifiedUserIds)
 		return err
 	}
 	return nil
@@ -59,7 +83,7 @@ func DeleteChatNotifications(userId int, notifiedUserId int) error {
 	_, err := sqlite.DB.Exec(query, userId, notifiedUserId)
 
 	if err != nil {
-		log.Printf("Error deleting notification: %v\n%v", err, userId, notifiedUserId)
+		log.Printf("Error deleting notification: %v\n%v %v", err, userId, notifiedUserId)
 		return err
 	}
 	return nil
@@ -74,14 +98,214 @@ func DeleteGroupChatNotifications(userId int, groupId int) error {
 	_, err := sqlite.DB.Exec(query, userId, groupId)
 
 	if err != nil {
-		log.Printf("Error deleting notification: %v\n%v", err, userId, groupId)
+		log.Printf("Error deleting notification: %v\n%v %v", err, userId, groupId)
+		return err
+	}
+	return nil
+}
+
+// GetChatMessage returns a single private or group message
+func GetChatMessage(messageId int) (models.ChatMessage, error) {
+	var message models.ChatMessage
+	err := sqlite.DB.QueryRow(`
+		SELECT id, sender_id, COALESCE(receiver_id, 0), COALESCE(group_id, 0), content, created_at, edited_at, deleted_at, COALESCE(reply_to_id, 0)
+		FROM messages WHERE id = ?
+	`, messageId).Scan(&message.ID, &message.SenderID, &message.ReceiverID, &message.GroupID, &message.Content, &message.CreatedAt, &message.EditedAt, &message.DeletedAt, &message.ReplyToID)
+	if err != nil {
+		log.Printf("Error retrieving message %d: %v", messageId, err)
+		return models.ChatMessage{}, err
+	}
+
+	messages := []models.ChatMessage{message}
+	if err := AddChatAttachments(messages); err != nil {
+		return models.ChatMessage{}, err
+	}
+	if err := addChatReactions(messages); err != nil {
+		return models.ChatMessage{}, err
+	}
+	if err := addReplyPreviews(messages); err != nil {
+		return models.ChatMessage{}, err
+	}
+	return messages[0], nil
+}
+
+// EditChatMessage replaces the content of a message and keeps the previous version in its edit history
+func EditChatMessage(messageId int, content string, editedAt time.Time) error {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO message_edits (message_id, content, edited_at)
+		SELECT id, content, ? FROM messages WHERE id = ? AND deleted_at IS NULL
+	`, editedAt, messageId)
+	if err != nil {
+		log.Printf("Error saving message edit: %v", err)
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE messages SET content = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL", content, editedAt, messageId)
+	if err != nil {
+		log.Printf("Error editing message: %v", err)
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing message edit: %v", err)
 		return err
 	}
 	return nil
 }
 
-func GetChatQuery(userAId, userBId int) (models.Chat, error) {
-	rows, err := sqlite.DB.Query("SELECT id, sender_id, content, created_at FROM messages WHERE (sender_id = ? AND receiver_id = ?) OR (receiver_id = ? AND sender_id  = ?) ORDER BY created_at ASC", userAId, userBId, userAId, userBId)
+// DeleteChatMessage turns a message into a tombstone: the row stays in the conversation
+// with its content, edit history and attachments removed, and it no longer counts as unread.
+// It returns the stored file names of the removed attachments, for the caller to delete.
+func DeleteChatMessage(messageId int, deletedAt time.Time) ([]string, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE messages SET content = '', deleted_at = ? WHERE id = ? AND deleted_at IS NULL", deletedAt, messageId)
+	if err != nil {
+		log.Printf("Error deleting message: %v", err)
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec("DELETE FROM message_edits WHERE message_id = ?", messageId); err != nil {
+		log.Printf("Error deleting message edits: %v", err)
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM chat_notifications WHERE message_id = ?", messageId); err != nil {
+		log.Printf("Error deleting chat notifications: %v", err)
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM message_reactions WHERE message_id = ?", messageId); err != nil {
+		log.Printf("Error deleting message reactions: %v", err)
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM pinned_messages WHERE message_id = ?", messageId); err != nil {
+		log.Printf("Error unpinning deleted message: %v", err)
+		return nil, err
+	}
+
+	rows, err := tx.Query("DELETE FROM message_attachments WHERE message_id = ? RETURNING file_name", messageId)
+	if err != nil {
+		log.Printf("Error deleting message attachments: %v", err)
+		return nil, err
+	}
+	var fileNames []string
+	for rows.Next() {
+		var fileName string
+		if err := rows.Scan(&fileName); err != nil {
+			rows.Close()
+			log.Printf("Error scanning attachment file name: %v", err)
+			return nil, err
+		}
+		fileNames = append(fileNames, fileName)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error during rows iteration: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing message delete: %v", err)
+		return nil, err
+	}
+	return fileNames, nil
+}
+
+// GetMessageEdits returns the previous versions of a message, oldest first
+func GetMessageEdits(messageId int) ([]models.MessageEdit, error) {
+	rows, err := sqlite.DB.Query("SELECT id, message_id, content, edited_at FROM message_edits WHERE message_id = ? ORDER BY id ASC", messageId)
+	if err != nil {
+		log.Printf("Error retrieving message edits: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []models.MessageEdit{}
+	for rows.Next() {
+		var edit models.MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Content, &edit.EditedAt); err != nil {
+			log.Printf("Error scanning message edit: %v", err)
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+// IsChatParticipant checks that the user can see the message: its sender or receiver,
+// or an accepted member of its group
+func IsChatParticipant(userId int, message models.ChatMessage) (bool, error) {
+	if message.GroupID != 0 {
+		status, err := GetMemberStatus(userId, message.GroupID)
+		if err != nil {
+			return false, err
+		}
+		return status == "accepted", nil
+	}
+	return userId == message.SenderID || userId == message.ReceiverID, nil
+}
+
+// CanSendChatMessage checks that the sender may write to the conversation: an accepted
+// group member for group chats, and an admin when the chat is announcement-only, or a
+// follow relationship and no block for private chats. Slow mode is checked separately.
+func CanSendChatMessage(senderId int, receiverId int, groupId int) (bool, error) {
+	if groupId != 0 {
+		status, err := GetMemberStatus(senderId, groupId)
+		if err != nil {
+			log.Printf("Error checking member status: %v", err)
+			return false, err
+		}
+		if status != "accepted" {
+			return false, nil
+		}
+		var announcementOnly bool
+		err = sqlite.DB.QueryRow("SELECT announcement_only FROM group_chat_settings WHERE group_id = ?", groupId).Scan(&announcementOnly)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Error retrieving group chat settings: %v", err)
+			return false, err
+		}
+		if !announcementOnly {
+			return true, nil
+		}
+		return IsGroupAdmin(senderId, groupId)
+	}
+	if receiverId == 0 || receiverId == senderId {
+		return false, nil
+	}
+	blocked, err := IsBlocked(senderId, receiverId)
+	if err != nil || blocked {
+		return false, err
+	}
+	follows, err := CheckIfUsersFollowsOrFollowed(senderId, receiverId)
+	if err != nil || follows {
+		return follows, err
+	}
+	return isAcceptedMessageRequest(senderId, receiverId)
+}
+
+// GetChatQuery returns up to limit messages of a private chat sent before the message
+// ID before (the latest ones when 0), oldest first. HasMore tells if older ones remain.
+func GetChatQuery(userAId, userBId, before, limit int) (models.Chat, error) {
+	rows, err := sqlite.DB.Query(`SELECT id, sender_id, content, created_at, edited_at, deleted_at, COALESCE(reply_to_id, 0) FROM messages
+	WHERE ((sender_id = ? AND receiver_id = ?) OR (receiver_id = ? AND sender_id  = ?)) AND (? = 0 OR id < ?)
+	ORDER BY id DESC LIMIT ?`, userAId, userBId, userAId, userBId, before, before, limit+1)
 	if err != nil {
 		log.Printf("Error retrieving chat: %v", err)
 		return models.Chat{}, err
@@ -98,7 +322,18 @@ func GetChatQuery(userAId, userBId int) (models.Chat, error) {
 		log.Printf("Error retrieving user: %v", err)
 		return models.Chat{}, err
 	}
-	allowChat, err := CheckIfUsersFollowsOrFollowed(userAId, userBId)
+	allowChat, err := CanSendChatMessage(userAId, userBId, 0)
+	if err != nil {
+		return models.Chat{}, err
+	}
+	if !allowChat {
+		// Users without a follow relationship can still send a message request
+		allowChat, err = CanSendMessageRequest(userAId, userBId)
+		if err != nil {
+			return models.Chat{}, err
+		}
+	}
+	request, err := GetMessageRequestDirection(userAId, userBId)
 	if err != nil {
 		return models.Chat{}, err
 	}
@@ -106,20 +341,37 @@ func GetChatQuery(userAId, userBId int) (models.Chat, error) {
 		UserA:     userA,
 		UserB:     userB,
 		AllowChat: allowChat,
+		Request:   request,
 	}
 	for rows.Next() {
 		var n models.ChatMessage
-		if err := rows.Scan(&n.ID, &n.SenderID, &n.Content, &n.CreatedAt); err != nil {
+		if err := rows.Scan(&n.ID, &n.SenderID, &n.Content, &n.CreatedAt, &n.EditedAt, &n.DeletedAt, &n.ReplyToID); err != nil {
 			log.Printf("Error scanning chat row: %v", err)
 			return models.Chat{}, err
 		}
 		chat.Messages = append(chat.Messages, n)
 	}
+	chat.Messages, chat.HasMore = pageOfMessages(chat.Messages, limit)
+	if err := addReadReceipts(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
+	if err := AddChatAttachments(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
+	if err := addChatReactions(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
+	if err := addReplyPreviews(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
 	return chat, nil
 }
 
-func GetGroupChatQuery(groupId int) (models.Chat, error) {
-	rows, err := sqlite.DB.Query("SELECT id, sender_id, content, created_at FROM messages WHERE group_id = ? ORDER BY created_at ASC", groupId)
+// GetGroupChatQuery is GetChatQuery for a group chat
+func GetGroupChatQuery(groupId, before, limit int) (models.Chat, error) {
+	rows, err := sqlite.DB.Query(`SELECT id, sender_id, content, created_at, edited_at, deleted_at, COALESCE(reply_to_id, 0) FROM messages
+	WHERE group_id = ? AND (? = 0 OR id < ?)
+	ORDER BY id DESC LIMIT ?`, groupId, before, before, limit+1)
 	if err != nil {
 		log.Printf("Error retrieving chat: %v", err)
 		return models.Chat{}, err
@@ -136,7 +388,7 @@ func GetGroupChatQuery(groupId int) (models.Chat, error) {
 	}
 	for rows.Next() {
 		var n models.ChatMessage
-		if err := rows.Scan(&n.ID, &n.SenderID, &n.Content, &n.CreatedAt); err != nil {
+		if err := rows.Scan(&n.ID, &n.SenderID, &n.Content, &n.CreatedAt, &n.EditedAt, &n.DeletedAt, &n.ReplyToID); err != nil {
 			log.Printf("Error scanning chat row: %v", err)
 			return models.Chat{}, err
 		}
@@ -150,12 +402,38 @@ func GetGroupChatQuery(groupId int) (models.Chat, error) {
 			chat.Group.Members = append(chat.Group.Members, addUser)
 		}
 	}
+	chat.Messages, chat.HasMore = pageOfMessages(chat.Messages, limit)
+	if err := addReadReceipts(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
+	if err := AddChatAttachments(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
+	if err := addChatReactions(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
+	if err := addReplyPreviews(chat.Messages); err != nil {
+		return models.Chat{}, err
+	}
 
 	return chat, nil
 }
 
+// pageOfMessages takes messages loaded newest first with one extra row past the
+// limit, and returns the page oldest first and whether older messages remain.
+func pageOfMessages(messages []models.ChatMessage, limit int) ([]models.ChatMessage, bool) {
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, hasMore
+}
+
 func GetAllChatQuery(userId int) ([]models.Chat, error) {
-	rows, err := sqlite.DB.Query(`SELECT id, sender_id, receiver_id, group_id, content, created_at FROM messages 
+	rows, err := sqlite.DB.Query(`SELECT id, sender_id, receiver_id, group_id, content, created_at, edited_at, deleted_at FROM messages 
 	WHERE (sender_id = ? AND receiver_id IS NOT NULL) OR receiver_id = ? OR group_id IN (SELECT group_id FROM group_members WHERE user_id = ? AND status = "accepted") ORDER BY created_at DESC`, userId, userId, userId)
 	if err != nil {
 		log.Printf("Error retrieving chat: %v", err)
@@ -172,7 +450,7 @@ func GetAllChatQuery(userId int) ([]models.Chat, error) {
 		// Since GroupID and RecieverID might be NULL, I scan them into an []byte then process them later depending n which is nil
 		var recieverIdarr []byte
 		var groupIdarr []byte
-		if err := rows.Scan(&message.ID, &message.SenderID, &recieverIdarr, &groupIdarr, &message.Content, &message.CreatedAt); err != nil {
+		if err := rows.Scan(&message.ID, &message.SenderID, &recieverIdarr, &groupIdarr, &message.Content, &message.CreatedAt, &message.EditedAt, &message.DeletedAt); err != nil {
 			log.Printf("Error scanning chat row: %v", err)
 			return nil, err
 		}
@@ -252,9 +530,28 @@ func GetAllChatQuery(userId int) ([]models.Chat, error) {
 	return chats, nil
 }
 
-func GetLastMessageOfAllChatQuery(userId int) ([]models.Chat, error) {
-	rows, err := sqlite.DB.Query(`SELECT id, sender_id, receiver_id, group_id, content, created_at FROM messages 
-	WHERE (sender_id = ? AND receiver_id IS NOT NULL) OR receiver_id = ? OR group_id IN (SELECT group_id FROM group_members WHERE user_id = ? AND status = "accepted") ORDER BY created_at DESC`, userId, userId, userId)
+// GetLastMessageOfAllChatQuery returns the conversations of the user with only their
+// latest message, most recently active first. Archived conversations are only
+// returned, on their own, when archived is true.
+func GetLastMessageOfAllChatQuery(userId int, archived bool) ([]models.Chat, error) {
+	rows, err := sqlite.DB.Query(`SELECT m.id, m.sender_id, m.receiver_id, m.group_id, m.content, m.created_at, m.edited_at, m.deleted_at,
+		COALESCE(cs.muted, FALSE), cs.muted_until, cs.archived_message_id
+	FROM (
+		SELECT *, ROW_NUMBER() OVER (
+			PARTITION BY CASE WHEN group_id IS NOT NULL THEN -group_id WHEN sender_id = $1 THEN receiver_id ELSE sender_id END
+			ORDER BY id DESC
+		) AS position
+		FROM messages
+		WHERE (sender_id = $1 AND receiver_id IS NOT NULL) OR receiver_id = $1 OR group_id IN (SELECT group_id FROM group_members WHERE user_id = $1 AND status = "accepted")
+	) AS m
+	LEFT JOIN conversation_settings cs ON cs.user_id = $1 AND cs.group_id = COALESCE(m.group_id, 0)
+		AND cs.partner_id = CASE WHEN m.group_id IS NOT NULL THEN 0 WHEN m.sender_id = $1 THEN m.receiver_id ELSE m.sender_id END
+	WHERE m.position = 1 AND NOT EXISTS (
+		-- Message requests the user has not accepted are listed on their own
+		SELECT 1 FROM message_requests r
+		WHERE m.group_id IS NULL AND r.receiver_id = $1 AND r.status != 'accepted'
+			AND r.sender_id = CASE WHEN m.sender_id = $1 THEN m.receiver_id ELSE m.sender_id END
+	) ORDER BY m.id DESC`, userId)
 	if err != nil {
 		log.Printf("Error retrieving messages: %v", err)
 		return nil, err
@@ -265,15 +562,26 @@ func GetLastMessageOfAllChatQuery(userId int) ([]models.Chat, error) {
 	groupIds := make(map[int]int)
 	var chats []models.Chat
 
+	now := time.Now()
 	for rows.Next() {
 		var message models.ChatMessage
 		// Since GroupID and RecieverID might be NULL, I scan them into an []byte then process them later depending n which is nil
 		var receiverIdarr []byte
 		var groupIdarr []byte
-		if err := rows.Scan(&message.ID, &message.SenderID, &receiverIdarr, &groupIdarr, &message.Content, &message.CreatedAt); err != nil {
+		var muted bool
+		var mutedUntil *time.Time
+		var archivedMessageId sql.NullInt64
+		if err := rows.Scan(&message.ID, &message.SenderID, &receiverIdarr, &groupIdarr, &message.Content, &message.CreatedAt, &message.EditedAt, &message.DeletedAt,
+			&muted, &mutedUntil, &archivedMessageId); err != nil {
 			log.Printf("Error scanning message row: %v", err)
 			return nil, err
 		}
+		// Any message newer than the one the conversation was archived at brings it back
+		if (archivedMessageId.Valid && archivedMessageId.Int64 >= int64(message.ID)) != archived {
+			continue
+		}
+		muted, _ = activeMute(muted, mutedUntil, now)
+
 		if groupIdarr != nil {
 			// Chat Message
 			message.GroupID, _ = strconv.Atoi(string(groupIdarr[:]))
@@ -302,6 +610,8 @@ func GetLastMessageOfAllChatQuery(userId int) ([]models.Chat, error) {
 				chats = append(chats, models.Chat{
 					Group:        group,
 					Notification: notification,
+					Muted:        muted,
+					Archived:     archived,
 				})
 				groupIds[message.GroupID] = len(chats) - 1
 
@@ -340,6 +650,8 @@ func GetLastMessageOfAllChatQuery(userId int) ([]models.Chat, error) {
 					UserA:        userA,
 					UserB:        userB,
 					Notification: notification,
+					Muted:        muted,
+					Archived:     archived,
 				})
 				userBIds[userBId] = len(chats) - 1
 				chats[userBIds[userBId]].Messages = append(chats[userBIds[userBId]].Messages, message)
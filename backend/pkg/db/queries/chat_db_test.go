@@ -0,0 +1,105 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite/sqlitetest"
+	"backend/pkg/models"
+	"database/sql"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestEditAndDeleteChatMessage(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+
+	message, err := CreateChatMessage(models.ChatMessage{
+		SenderID:   alice,
+		ReceiverID: bob,
+		Content:    "original",
+		CreatedAt:  time.Now(),
+		Attachments: []models.ChatAttachment{
+			{FileName: "stored-1.png", Name: "photo.png", ContentType: "image/png", Size: 10},
+			{FileName: "stored-2.pdf", Name: "notes.pdf", ContentType: "application/pdf", Size: 20},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateChatNotifications(message.ID, []int{bob}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name      string
+		delete    bool   // Delete the message rather than edit it
+		content   string // New content of an edit
+		messageID int    // Defaults to the message
+		wantErr   error
+		files     []string // Attachment files a delete returns
+		current   string   // Content afterwards
+		history   []string // Previous versions afterwards, oldest first
+	}{
+		{name: "first edit", content: "edited once", current: "edited once", history: []string{"original"}},
+		{name: "second edit", content: "edited twice", current: "edited twice", history: []string{"original", "edited once"}},
+		{name: "missing message", content: "nothing", messageID: message.ID + 1, wantErr: sql.ErrNoRows, current: "edited twice", history: []string{"original", "edited once"}},
+		{name: "delete", delete: true, files: []string{"stored-1.png", "stored-2.pdf"}, current: "", history: []string{}},
+		{name: "edit once deleted", content: "too late", wantErr: sql.ErrNoRows, current: "", history: []string{}},
+		{name: "delete twice", delete: true, wantErr: sql.ErrNoRows, current: "", history: []string{}},
+	}
+	for _, step := range steps {
+		messageID := message.ID
+		if step.messageID != 0 {
+			messageID = step.messageID
+		}
+		var files []string
+		if step.delete {
+			files, err = DeleteChatMessage(messageID, time.Now())
+		} else {
+			err = EditChatMessage(messageID, step.content, time.Now())
+		}
+		if err != step.wantErr {
+			t.Fatalf("%s: got error %v, want %v", step.name, err, step.wantErr)
+		}
+		slices.Sort(files)
+		if !slices.Equal(files, step.files) {
+			t.Errorf("%s: returned files %v, want %v", step.name, files, step.files)
+		}
+
+		stored, err := GetChatMessage(message.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Content != step.current {
+			t.Errorf("%s: content is %q, want %q", step.name, stored.Content, step.current)
+		}
+		edits, err := GetMessageEdits(message.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history := []string{}
+		for _, edit := range edits {
+			history = append(history, edit.Content)
+		}
+		if !slices.Equal(history, step.history) {
+			t.Errorf("%s: history is %v, want %v", step.name, history, step.history)
+		}
+	}
+
+	// The tombstone keeps its place in the conversation, without anything it held
+	stored, err := GetChatMessage(message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.DeletedAt == nil || stored.EditedAt == nil {
+		t.Errorf("deleted message has edited_at %v and deleted_at %v, want both set", stored.EditedAt, stored.DeletedAt)
+	}
+	if len(stored.Attachments) != 0 {
+		t.Errorf("deleted message still has %d attachments", len(stored.Attachments))
+	}
+	if count := countChatNotifications(t, bob); count != 0 {
+		t.Errorf("deleted message still has %d notifications", count)
+	}
+}
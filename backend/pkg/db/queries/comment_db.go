@@ -63,14 +63,14 @@ func GetCommentByID(commentID int) (models.Comment, error) {
 	return comment, nil
 }
 
-func GetCommentsQuery(postID string) ([]models.Comment, error) {
+func GetCommentsQuery(postID string, viewerID int) ([]models.Comment, error) {
 	rows, err := sqlite.DB.Query(`
 		SELECT c.id, c.post_id, c.content, c.created_at, c.file, u.id, u.username, u.avatar_url
 		FROM comments c
 		JOIN users u ON c.user_id = u.id
-		WHERE c.post_id = ?
+		WHERE c.post_id = ? AND NOT `+hiddenUserCondition("c.user_id")+`
 		ORDER BY c.created_at DESC
-	`, postID)
+	`, postID, viewerID, viewerID)
 	if err != nil {
 		log.Printf("Error retrieving comments: %v", err)
 		return nil, err
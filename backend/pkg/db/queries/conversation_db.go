@@ -0,0 +1,118 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// latestConversationMessageQuery selects the ID of the latest message of the private chat
+// between the first two arguments, or of the group chat given as third, 0 when empty
+const latestConversationMessageQuery = `
+	SELECT COALESCE(MAX(id), 0) FROM messages
+	WHERE CASE WHEN ?3 != 0 THEN group_id = ?3
+		ELSE (sender_id = ?1 AND receiver_id = ?2) OR (sender_id = ?2 AND receiver_id = ?1) END
+`
+
+// GetConversationSettings returns the settings userId chose for the private chat with
+// partnerId, or for the group chat groupId. Expired mutes are reported as unmuted.
+func GetConversationSettings(userId int, partnerId int, groupId int) (models.ConversationSettings, error) {
+	settings := models.ConversationSettings{UserID: partnerId, GroupID: groupId}
+
+	var muted bool
+	var mutedUntil *time.Time
+	var archivedMessageId sql.NullInt64
+	err := sqlite.DB.QueryRow(`
+		SELECT muted, muted_until, archived_message_id FROM conversation_settings
+		WHERE user_id = ? AND partner_id = ? AND group_id = ?
+	`, userId, partnerId, groupId).Scan(&muted, &mutedUntil, &archivedMessageId)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving conversation settings: %v", err)
+		return models.ConversationSettings{}, err
+	}
+
+	var latestMessageId int64
+	if err := sqlite.DB.QueryRow(latestConversationMessageQuery, userId, partnerId, groupId).Scan(&latestMessageId); err != nil {
+		log.Printf("Error retrieving latest message: %v", err)
+		return models.ConversationSettings{}, err
+	}
+
+	settings.Muted, settings.MutedUntil = activeMute(muted, mutedUntil, time.Now())
+	settings.Archived = archivedMessageId.Valid && archivedMessageId.Int64 >= latestMessageId
+	return settings, nil
+}
+
+// SaveConversationSettings stores the settings of userId for a conversation. Archiving
+// hides the conversation until a message newer than its current latest one arrives.
+func SaveConversationSettings(userId int, settings models.ConversationSettings) error {
+	muted := settings.Muted
+	mutedUntil := settings.MutedUntil
+	if !muted {
+		mutedUntil = nil
+	}
+
+	_, err := sqlite.DB.Exec(`
+		INSERT INTO conversation_settings (user_id, partner_id, group_id, muted, muted_until, archived_message_id)
+		VALUES (?1, ?2, ?3, ?4, ?5, CASE WHEN ?6 THEN (`+latestConversationMessageQuery+`) END)
+		ON CONFLICT (user_id, partner_id, group_id) DO UPDATE SET
+			muted = excluded.muted,
+			muted_until = excluded.muted_until,
+			archived_message_id = excluded.archived_message_id
+	`, userId, settings.UserID, settings.GroupID, muted, mutedUntil, settings.Archived)
+	if err != nil {
+		log.Printf("Error saving conversation settings: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetUsersMutingConversation returns which of userIds have the private chat with
+// partnerId, or the group chat groupId, muted right now
+func GetUsersMutingConversation(userIds []int, partnerId int, groupId int) (map[int]bool, error) {
+	muting := make(map[int]bool)
+	if len(userIds) == 0 {
+		return muting, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(userIds)), ",")
+	args := []interface{}{partnerId, groupId}
+	for _, userId := range userIds {
+		args = append(args, userId)
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT user_id, muted_until FROM conversation_settings
+		WHERE partner_id = ? AND group_id = ? AND muted AND user_id IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		log.Printf("Error retrieving muted conversations: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		var userId int
+		var mutedUntil *time.Time
+		if err := rows.Scan(&userId, &mutedUntil); err != nil {
+			log.Printf("Error scanning muted conversation: %v", err)
+			return nil, err
+		}
+		muting[userId], _ = activeMute(true, mutedUntil, now)
+	}
+	return muting, rows.Err()
+}
+
+// activeMute tells whether a stored mute still applies at now, and until when
+func activeMute(muted bool, mutedUntil *time.Time, now time.Time) (bool, *time.Time) {
+	if !muted || (mutedUntil != nil && !mutedUntil.After(now)) {
+		return false, nil
+	}
+	return true, mutedUntil
+}
@@ -8,7 +8,8 @@ import (
 	"log"
 )
 
-// FollowUser adds a follow relationship to the database
+// FollowUser adds a follow relationship to the database. An accepted one also accepts
+// the message requests between the two users, as they can now chat.
 func FollowUser(followerID int, followedID int, status string) error {
 	// Prepare the SQL statement
 	query := `
@@ -21,6 +22,9 @@ func FollowUser(followerID int, followedID int, status string) error {
 	if err != nil {
 		return fmt.Errorf("failed to add follow relationship: %w", err)
 	}
+	if status == "accepted" {
+		return acceptMessageRequestsBetween(followerID, followedID)
+	}
 
 	return nil
 }
@@ -242,16 +246,20 @@ func DeclineFollowRequest(followerID int, followedID int) error {
 	return nil
 }
 
-// AcceptFollowRequest updates the status of a follow request to accepted
+// AcceptFollowRequest updates the status of a follow request to accepted, and accepts
+// the message requests between the two users
 func AcceptFollowRequest(followerID int, followedID int) error {
 	// Prepare the SQL statement
 	query := `UPDATE followers SET status = 'accepted' WHERE follower_id = ? AND followed_id = ? AND status = 'pending'`
 
 	// Execute the query
-	_, err := sqlite.DB.Exec(query, followerID, followedID)
+	result, err := sqlite.DB.Exec(query, followerID, followedID)
 	if err != nil {
 		log.Println("Error updating follow request status:", err)
 		return err
 	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		return acceptMessageRequestsBetween(followerID, followedID)
+	}
 	return nil
 }
@@ -0,0 +1,202 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Roles of an accepted group member. The group creator is always an admin.
+const (
+	GroupRoleMember = "member"
+	GroupRoleAdmin  = "admin"
+)
+
+// IsGroupAdmin checks whether the user is the creator of the group or an accepted member made admin
+func IsGroupAdmin(userId int, groupId int) (bool, error) {
+	var admin bool
+	err := sqlite.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM groups WHERE id = ?1 AND creator_id = ?2)
+			OR EXISTS (SELECT 1 FROM group_members WHERE group_id = ?1 AND user_id = ?2 AND status = 'accepted' AND role = 'admin')
+	`, groupId, userId).Scan(&admin)
+	if err != nil {
+		log.Printf("Error checking group admin: %v", err)
+		return false, err
+	}
+	return admin, nil
+}
+
+// SetGroupMemberRole makes an accepted member an admin or a regular member again.
+// It returns sql.ErrNoRows when the user is not an accepted member of the group.
+func SetGroupMemberRole(groupId int, userId int, role string) error {
+	result, err := sqlite.DB.Exec(`
+		UPDATE group_members SET role = ? WHERE group_id = ? AND user_id = ? AND status = 'accepted'
+	`, role, groupId, userId)
+	if err != nil {
+		log.Printf("Error updating group member role: %v", err)
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetGroupChatSettings returns the rules of a group chat, its admins and its pinned
+// messages, most recently pinned first. Groups that never changed them get the defaults.
+func GetGroupChatSettings(groupId int) (models.GroupChatSettings, error) {
+	settings := models.GroupChatSettings{GroupID: groupId, Admins: []int{}, PinnedMessages: []models.ChatMessage{}}
+
+	err := sqlite.DB.QueryRow(`
+		SELECT announcement_only, slow_mode_seconds FROM group_chat_settings WHERE group_id = ?
+	`, groupId).Scan(&settings.AnnouncementOnly, &settings.SlowModeSeconds)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving group chat settings: %v", err)
+		return models.GroupChatSettings{}, err
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT creator_id FROM groups WHERE id = ?1
+		UNION ALL
+		SELECT user_id FROM group_members
+		WHERE group_id = ?1 AND status = 'accepted' AND role = 'admin' AND user_id != (SELECT creator_id FROM groups WHERE id = ?1)
+	`, groupId)
+	if err != nil {
+		log.Printf("Error retrieving group admins: %v", err)
+		return models.GroupChatSettings{}, err
+	}
+	for rows.Next() {
+		var adminId int
+		if err := rows.Scan(&adminId); err != nil {
+			rows.Close()
+			log.Printf("Error scanning group admin: %v", err)
+			return models.GroupChatSettings{}, err
+		}
+		settings.Admins = append(settings.Admins, adminId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error during rows iteration: %v", err)
+		return models.GroupChatSettings{}, err
+	}
+
+	rows, err = sqlite.DB.Query(`
+		SELECT m.id, m.sender_id, m.group_id, m.content, m.created_at, m.edited_at, m.deleted_at
+		FROM pinned_messages p
+		JOIN messages m ON m.id = p.message_id
+		WHERE p.group_id = ?
+		ORDER BY p.pinned_at DESC, p.message_id DESC
+	`, groupId)
+	if err != nil {
+		log.Printf("Error retrieving pinned messages: %v", err)
+		return models.GroupChatSettings{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var message models.ChatMessage
+		if err := rows.Scan(&message.ID, &message.SenderID, &message.GroupID, &message.Content, &message.CreatedAt, &message.EditedAt, &message.DeletedAt); err != nil {
+			log.Printf("Error scanning pinned message: %v", err)
+			return models.GroupChatSettings{}, err
+		}
+		settings.PinnedMessages = append(settings.PinnedMessages, message)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error during rows iteration: %v", err)
+		return models.GroupChatSettings{}, err
+	}
+	if err := AddChatAttachments(settings.PinnedMessages); err != nil {
+		return models.GroupChatSettings{}, err
+	}
+	return settings, nil
+}
+
+// UpdateGroupChatSettings changes whether only admins can post in the group chat and
+// the slow mode interval, 0 to turn it off
+func UpdateGroupChatSettings(groupId int, announcementOnly bool, slowModeSeconds int) error {
+	_, err := sqlite.DB.Exec(`
+		INSERT INTO group_chat_settings (group_id, announcement_only, slow_mode_seconds, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (group_id) DO UPDATE SET
+			announcement_only = excluded.announcement_only,
+			slow_mode_seconds = excluded.slow_mode_seconds,
+			updated_at = excluded.updated_at
+	`, groupId, announcementOnly, slowModeSeconds)
+	if err != nil {
+		log.Printf("Error updating group chat settings: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CountPinnedMessages returns how many messages are pinned in the group chat
+func CountPinnedMessages(groupId int) (int, error) {
+	var count int
+	err := sqlite.DB.QueryRow("SELECT COUNT(*) FROM pinned_messages WHERE group_id = ?", groupId).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting pinned messages: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// PinChatMessage pins a message of the group chat, pinning it again moves it to the top
+func PinChatMessage(groupId int, messageId int, pinnedBy int) error {
+	_, err := sqlite.DB.Exec(`
+		INSERT INTO pinned_messages (group_id, message_id, pinned_by, pinned_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (group_id, message_id) DO UPDATE SET pinned_by = excluded.pinned_by, pinned_at = excluded.pinned_at
+	`, groupId, messageId, pinnedBy)
+	if err != nil {
+		log.Printf("Error pinning message: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UnpinChatMessage unpins a message of the group chat
+func UnpinChatMessage(groupId int, messageId int) error {
+	_, err := sqlite.DB.Exec("DELETE FROM pinned_messages WHERE group_id = ? AND message_id = ?", groupId, messageId)
+	if err != nil {
+		log.Printf("Error unpinning message: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetSlowModeWait returns how long the user has to wait before posting again in the
+// group chat, 0 when they can post now. Admins are not subject to slow mode.
+func GetSlowModeWait(userId int, groupId int, now time.Time) (time.Duration, error) {
+	var slowModeSeconds int
+	err := sqlite.DB.QueryRow("SELECT slow_mode_seconds FROM group_chat_settings WHERE group_id = ?", groupId).Scan(&slowModeSeconds)
+	if err == sql.ErrNoRows || (err == nil && slowModeSeconds == 0) {
+		return 0, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving slow mode: %v", err)
+		return 0, err
+	}
+
+	admin, err := IsGroupAdmin(userId, groupId)
+	if err != nil || admin {
+		return 0, err
+	}
+
+	var lastSentAt time.Time
+	err = sqlite.DB.QueryRow(`
+		SELECT created_at FROM messages WHERE group_id = ? AND sender_id = ? ORDER BY id DESC LIMIT 1
+	`, groupId, userId).Scan(&lastSentAt)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving last message: %v", err)
+		return 0, err
+	}
+
+	wait := lastSentAt.Add(time.Duration(slowModeSeconds) * time.Second).Sub(now)
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
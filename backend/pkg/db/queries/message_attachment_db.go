@@ -0,0 +1,90 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// chatAttachmentURL is where participants download an attachment, by its ID
+const chatAttachmentURL = "/api/chat/attachment?id=%d"
+
+// insertChatAttachments stores the attachments of a message being created in tx
+func insertChatAttachments(tx *sql.Tx, messageId int, attachments []models.ChatAttachment) error {
+	for i := range attachments {
+		attachment := &attachments[i]
+		result, err := tx.Exec(`
+			INSERT INTO message_attachments (message_id, file_name, original_name, content_type, size)
+			VALUES (?, ?, ?, ?, ?)
+		`, messageId, attachment.FileName, attachment.Name, attachment.ContentType, attachment.Size)
+		if err != nil {
+			log.Printf("Error inserting message attachment: %v", err)
+			return err
+		}
+		attachmentId, err := result.LastInsertId()
+		if err != nil {
+			log.Printf("Error retrieving last insert ID: %v", err)
+			return err
+		}
+		attachment.ID = int(attachmentId)
+		attachment.MessageID = messageId
+		attachment.URL = fmt.Sprintf(chatAttachmentURL, attachment.ID)
+	}
+	return nil
+}
+
+// GetChatAttachment returns a single attachment, with the name of its stored file
+func GetChatAttachment(attachmentId int) (models.ChatAttachment, error) {
+	var attachment models.ChatAttachment
+	err := sqlite.DB.QueryRow(`
+		SELECT id, message_id, file_name, original_name, content_type, size
+		FROM message_attachments WHERE id = ?
+	`, attachmentId).Scan(&attachment.ID, &attachment.MessageID, &attachment.FileName, &attachment.Name, &attachment.ContentType, &attachment.Size)
+	if err != nil {
+		log.Printf("Error retrieving attachment %d: %v", attachmentId, err)
+		return models.ChatAttachment{}, err
+	}
+	attachment.URL = fmt.Sprintf(chatAttachmentURL, attachment.ID)
+	return attachment, nil
+}
+
+// AddChatAttachments fills in Attachments on each of the messages
+func AddChatAttachments(messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messages)), ",")
+	args := make([]interface{}, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, message := range messages {
+		args[i] = message.ID
+		index[message.ID] = i
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT id, message_id, file_name, original_name, content_type, size FROM message_attachments
+		WHERE message_id IN (`+placeholders+`)
+		ORDER BY id ASC
+	`, args...)
+	if err != nil {
+		log.Printf("Error retrieving message attachments: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attachment models.ChatAttachment
+		if err := rows.Scan(&attachment.ID, &attachment.MessageID, &attachment.FileName, &attachment.Name, &attachment.ContentType, &attachment.Size); err != nil {
+			log.Printf("Error scanning message attachment: %v", err)
+			return err
+		}
+		attachment.URL = fmt.Sprintf(chatAttachmentURL, attachment.ID)
+		i := index[attachment.MessageID]
+		messages[i].Attachments = append(messages[i].Attachments, attachment)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,153 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// ReactToChatMessage sets the user's reaction on a message, replacing their previous
+// one. Reacting again with the same type removes the reaction, which is reported by
+// returning true, as AddOrUpdateReaction does for posts and comments.
+func ReactToChatMessage(messageId int, userId int, reactionTypeId int) (bool, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND reaction_type_id = ?
+	`, messageId, userId, reactionTypeId)
+	if err != nil {
+		log.Printf("Error removing message reaction: %v", err)
+		return false, err
+	}
+	removed, _ := result.RowsAffected()
+
+	if removed == 0 {
+		_, err = tx.Exec(`
+			INSERT INTO message_reactions (message_id, user_id, reaction_type_id) VALUES (?, ?, ?)
+			ON CONFLICT (message_id, user_id) DO UPDATE SET reaction_type_id = excluded.reaction_type_id, created_at = CURRENT_TIMESTAMP
+		`, messageId, userId, reactionTypeId)
+		if err != nil {
+			log.Printf("Error adding message reaction: %v", err)
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing message reaction: %v", err)
+		return false, err
+	}
+	return removed > 0, nil
+}
+
+// IsValidReply checks that message may quote the message replyToId: an earlier,
+// not deleted message of the same conversation
+func IsValidReply(replyToId int, message models.ChatMessage) (bool, error) {
+	quoted, err := GetChatMessage(replyToId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if quoted.DeletedAt != nil {
+		return false, nil
+	}
+	if message.GroupID != 0 {
+		return quoted.GroupID == message.GroupID, nil
+	}
+	return quoted.GroupID == 0 &&
+		((quoted.SenderID == message.SenderID && quoted.ReceiverID == message.ReceiverID) ||
+			(quoted.SenderID == message.ReceiverID && quoted.ReceiverID == message.SenderID)), nil
+}
+
+// addChatReactions fills in Reactions on each of the messages, oldest reaction first
+func addChatReactions(messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messages)), ",")
+	args := make([]interface{}, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, message := range messages {
+		args[i] = message.ID
+		index[message.ID] = i
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT mr.message_id, mr.user_id, rt.id, rt.name, rt.icon_url
+		FROM message_reactions mr
+		JOIN reaction_types rt ON rt.id = mr.reaction_type_id
+		WHERE mr.message_id IN (`+placeholders+`)
+		ORDER BY mr.created_at ASC
+	`, args...)
+	if err != nil {
+		log.Printf("Error retrieving message reactions: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageId int
+		var reaction models.ChatReaction
+		if err := rows.Scan(&messageId, &reaction.UserID, &reaction.ReactionTypeID, &reaction.Name, &reaction.IconURL); err != nil {
+			log.Printf("Error scanning message reaction: %v", err)
+			return err
+		}
+		i := index[messageId]
+		messages[i].Reactions = append(messages[i].Reactions, reaction)
+	}
+	return rows.Err()
+}
+
+// addReplyPreviews fills in ReplyTo on each of the messages quoting another one
+func addReplyPreviews(messages []models.ChatMessage) error {
+	var args []interface{}
+	for _, message := range messages {
+		if message.ReplyToID != 0 {
+			args = append(args, message.ReplyToID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+	rows, err := sqlite.DB.Query(`
+		SELECT id, sender_id, COALESCE(receiver_id, 0), COALESCE(group_id, 0), content, created_at, edited_at, deleted_at
+		FROM messages WHERE id IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		log.Printf("Error retrieving quoted messages: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	quoted := make(map[int]*models.ChatMessage)
+	for rows.Next() {
+		var q models.ChatMessage
+		if err := rows.Scan(&q.ID, &q.SenderID, &q.ReceiverID, &q.GroupID, &q.Content, &q.CreatedAt, &q.EditedAt, &q.DeletedAt); err != nil {
+			log.Printf("Error scanning quoted message: %v", err)
+			return err
+		}
+		quoted[q.ID] = &q
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error during rows iteration: %v", err)
+		return err
+	}
+
+	for i := range messages {
+		if messages[i].ReplyToID != 0 {
+			messages[i].ReplyTo = quoted[messages[i].ReplyToID]
+		}
+	}
+	return nil
+}
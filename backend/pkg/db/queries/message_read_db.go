@@ -0,0 +1,149 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// MarkChatReadUpTo records that readerId has read the private messages partnerId sent
+// them, up to and including upToMessageId (every message when 0), and clears the
+// matching chat notifications. It returns the highest message ID that was newly
+// marked as read, 0 if there was nothing left to mark.
+func MarkChatReadUpTo(readerId int, partnerId int, upToMessageId int, readAt time.Time) (int, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	lastReadId, err := insertMessageReads(tx, `
+		INSERT OR IGNORE INTO message_reads (message_id, user_id, read_at)
+		SELECT id, ?, ? FROM messages
+		WHERE sender_id = ? AND receiver_id = ? AND (? = 0 OR id <= ?)
+		RETURNING message_id
+	`, readerId, readAt, partnerId, readerId, upToMessageId, upToMessageId)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM chat_notifications WHERE notifiedUser_id = ? AND message_id IN (
+			SELECT id FROM messages WHERE sender_id = ? AND group_id IS NULL AND (? = 0 OR id <= ?)
+		)
+	`, readerId, partnerId, upToMessageId, upToMessageId)
+	if err != nil {
+		log.Printf("Error deleting chat notifications: %v", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing read receipts: %v", err)
+		return 0, err
+	}
+	return lastReadId, nil
+}
+
+// MarkGroupChatReadUpTo is MarkChatReadUpTo for the messages other members sent to a group chat.
+func MarkGroupChatReadUpTo(readerId int, groupId int, upToMessageId int, readAt time.Time) (int, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	lastReadId, err := insertMessageReads(tx, `
+		INSERT OR IGNORE INTO message_reads (message_id, user_id, read_at)
+		SELECT id, ?, ? FROM messages
+		WHERE group_id = ? AND sender_id != ? AND (? = 0 OR id <= ?)
+		RETURNING message_id
+	`, readerId, readAt, groupId, readerId, upToMessageId, upToMessageId)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM chat_notifications WHERE notifiedUser_id = ? AND message_id IN (
+			SELECT id FROM messages WHERE group_id = ? AND (? = 0 OR id <= ?)
+		)
+	`, readerId, groupId, upToMessageId, upToMessageId)
+	if err != nil {
+		log.Printf("Error deleting group chat notifications: %v", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing read receipts: %v", err)
+		return 0, err
+	}
+	return lastReadId, nil
+}
+
+// insertMessageReads runs an insert returning the IDs of the newly read messages and returns the highest one.
+func insertMessageReads(tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		log.Printf("Error inserting read receipts: %v", err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	lastReadId := 0
+	for rows.Next() {
+		var messageId int
+		if err := rows.Scan(&messageId); err != nil {
+			log.Printf("Error scanning read receipt: %v", err)
+			return 0, err
+		}
+		if messageId > lastReadId {
+			lastReadId = messageId
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error during rows iteration: %v", err)
+		return 0, err
+	}
+	return lastReadId, nil
+}
+
+// addReadReceipts fills in ReadBy on each of the messages.
+func addReadReceipts(messages []models.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messages)), ",")
+	args := make([]interface{}, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, message := range messages {
+		args[i] = message.ID
+		index[message.ID] = i
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT message_id, user_id FROM message_reads
+		WHERE message_id IN (`+placeholders+`)
+		ORDER BY read_at ASC
+	`, args...)
+	if err != nil {
+		log.Printf("Error retrieving read receipts: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageId, userId int
+		if err := rows.Scan(&messageId, &userId); err != nil {
+			log.Printf("Error scanning read receipt: %v", err)
+			return err
+		}
+		i := index[messageId]
+		messages[i].ReadBy = append(messages[i].ReadBy, userId)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,165 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/db/sqlite/sqlitetest"
+	"backend/pkg/models"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// sendTestMessage stores a private message when receiverID is set, a group one otherwise
+func sendTestMessage(t *testing.T, senderID, receiverID, groupID int, content string) int {
+	t.Helper()
+	message, err := CreateChatMessage(models.ChatMessage{SenderID: senderID, ReceiverID: receiverID, GroupID: groupID, Content: content, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.ID
+}
+
+// createTestGroup creates a group the members have all joined
+func createTestGroup(t *testing.T, creatorID int, memberIDs ...int) int {
+	t.Helper()
+	var groupID int
+	if err := sqlite.DB.QueryRow("INSERT INTO groups (name, description, creator_id, image_url) VALUES ('Test group', '', ?, '') RETURNING id", creatorID).Scan(&groupID); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range append([]int{creatorID}, memberIDs...) {
+		if _, err := sqlite.DB.Exec("INSERT INTO group_members (group_id, user_id, inviter_id, status) VALUES (?, ?, ?, 'accepted')", groupID, userID, creatorID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return groupID
+}
+
+// readBy returns who has read the message
+func readBy(t *testing.T, messageID int) []int {
+	t.Helper()
+	messages := []models.ChatMessage{{ID: messageID}}
+	if err := addReadReceipts(messages); err != nil {
+		t.Fatal(err)
+	}
+	return messages[0].ReadBy
+}
+
+func countChatNotifications(t *testing.T, userID int) int {
+	t.Helper()
+	var count int
+	if err := sqlite.DB.QueryRow("SELECT COUNT(*) FROM chat_notifications WHERE notifiedUser_id = ?", userID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestMarkChatReadUpTo(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+	carol := sqlitetest.CreateUser(t, "carol")
+
+	first := sendTestMessage(t, bob, alice, 0, "first")
+	second := sendTestMessage(t, bob, alice, 0, "second")
+	third := sendTestMessage(t, bob, alice, 0, "third")
+	reply := sendTestMessage(t, alice, bob, 0, "reply")
+	other := sendTestMessage(t, carol, alice, 0, "other chat")
+	for _, messageID := range []int{first, second, third, other} {
+		if err := CreateChatNotifications(messageID, []int{alice}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name            string
+		reader, partner int
+		upTo            int
+		want            int // Highest message newly read
+		notifications   int // Chat notifications of alice left afterwards
+	}{
+		{name: "up to the first", reader: alice, partner: bob, upTo: first, want: first, notifications: 3},
+		{name: "the first again", reader: alice, partner: bob, upTo: first, want: 0, notifications: 3},
+		{name: "everything", reader: alice, partner: bob, upTo: 0, want: third, notifications: 1},
+		{name: "nothing left", reader: alice, partner: bob, upTo: 0, want: 0, notifications: 1},
+		{name: "the other side", reader: bob, partner: alice, upTo: 0, want: reply, notifications: 1},
+		{name: "a chat without messages", reader: bob, partner: carol, upTo: 0, want: 0, notifications: 1},
+	}
+	for _, step := range steps {
+		got, err := MarkChatReadUpTo(step.reader, step.partner, step.upTo, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("%s: newly read up to %d, want %d", step.name, got, step.want)
+		}
+		if count := countChatNotifications(t, alice); count != step.notifications {
+			t.Errorf("%s: %d notifications left, want %d", step.name, count, step.notifications)
+		}
+	}
+
+	receipts := []struct {
+		messageID int
+		want      []int
+	}{
+		{first, []int{alice}},
+		{third, []int{alice}},
+		{reply, []int{bob}},
+		{other, nil},
+	}
+	for _, receipt := range receipts {
+		if got := readBy(t, receipt.messageID); !slices.Equal(got, receipt.want) {
+			t.Errorf("message %d read by %v, want %v", receipt.messageID, got, receipt.want)
+		}
+	}
+}
+
+func TestMarkGroupChatReadUpTo(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+	carol := sqlitetest.CreateUser(t, "carol")
+	groupID := createTestGroup(t, alice, bob, carol)
+	otherGroupID := createTestGroup(t, bob, alice)
+
+	fromBob := sendTestMessage(t, bob, 0, groupID, "from bob")
+	fromAlice := sendTestMessage(t, alice, 0, groupID, "from alice")
+	fromCarol := sendTestMessage(t, carol, 0, groupID, "from carol")
+	elsewhere := sendTestMessage(t, bob, 0, otherGroupID, "other group")
+
+	steps := []struct {
+		name   string
+		reader int
+		upTo   int
+		want   int
+	}{
+		{name: "up to bob's", reader: alice, upTo: fromBob, want: fromBob},
+		{name: "skips their own message", reader: alice, upTo: fromAlice, want: 0},
+		{name: "everything", reader: alice, upTo: 0, want: fromCarol},
+		{name: "another member", reader: bob, upTo: 0, want: fromCarol},
+	}
+	for _, step := range steps {
+		got, err := MarkGroupChatReadUpTo(step.reader, groupID, step.upTo, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("%s: newly read up to %d, want %d", step.name, got, step.want)
+		}
+	}
+
+	receipts := []struct {
+		messageID int
+		want      []int
+	}{
+		{fromBob, []int{alice}},
+		{fromAlice, []int{bob}},
+		{fromCarol, []int{alice, bob}},
+		{elsewhere, nil},
+	}
+	for _, receipt := range receipts {
+		if got := readBy(t, receipt.messageID); !slices.Equal(got, receipt.want) {
+			t.Errorf("message %d read by %v, want %v", receipt.messageID, got, receipt.want)
+		}
+	}
+}
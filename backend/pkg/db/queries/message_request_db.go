@@ -0,0 +1,214 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Who may send a user message requests, chosen in their privacy settings
+const (
+	MessageRequestsAnyone    = "anyone"
+	MessageRequestsFollowers = "followers" // Users following them, or asking to
+	MessageRequestsNobody    = "nobody"
+)
+
+// States of a message request
+const (
+	MessageRequestPending  = "pending"
+	MessageRequestAccepted = "accepted"
+	MessageRequestDeclined = "declined"
+)
+
+// GetMessageRequestStatus returns the state of the message request senderId sent to
+// receiverId, or an empty string when there is none
+func GetMessageRequestStatus(senderId int, receiverId int) (string, error) {
+	var status string
+	err := sqlite.DB.QueryRow("SELECT status FROM message_requests WHERE sender_id = ? AND receiver_id = ?", senderId, receiverId).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving message request: %v", err)
+		return "", err
+	}
+	return status, nil
+}
+
+// isAcceptedMessageRequest checks whether either user accepted a message request from the other
+func isAcceptedMessageRequest(userA int, userB int) (bool, error) {
+	var accepted bool
+	err := sqlite.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM message_requests
+			WHERE status = 'accepted' AND ((sender_id = ?1 AND receiver_id = ?2) OR (sender_id = ?2 AND receiver_id = ?1))
+		)
+	`, userA, userB).Scan(&accepted)
+	if err != nil {
+		log.Printf("Error checking message requests: %v", err)
+		return false, err
+	}
+	return accepted, nil
+}
+
+// heldMessageRequestCondition is an SQL condition true when a message request between
+// the users in the two columns is pending or declined, so their conversation is held back
+func heldMessageRequestCondition(userA string, userB string) string {
+	return fmt.Sprintf(`EXISTS (
+		SELECT 1 FROM message_requests
+		WHERE status != 'accepted' AND ((sender_id = %[1]s AND receiver_id = %[2]s) OR (sender_id = %[2]s AND receiver_id = %[1]s))
+	)`, userA, userB)
+}
+
+// acceptMessageRequestsBetween accepts the requests the users sent each other, once
+// a follow lets them chat anyway
+func acceptMessageRequestsBetween(userA int, userB int) error {
+	_, err := sqlite.DB.Exec(`
+		UPDATE message_requests SET status = 'accepted', updated_at = CURRENT_TIMESTAMP
+		WHERE status != 'accepted' AND ((sender_id = ?1 AND receiver_id = ?2) OR (sender_id = ?2 AND receiver_id = ?1))
+	`, userA, userB)
+	if err != nil {
+		log.Printf("Error accepting message requests: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CanSendMessageRequest checks whether senderId may message receiverId through a
+// message request: they are not blocked, the receiver accepts requests from them,
+// did not decline an earlier one and has no request of their own waiting for the sender.
+func CanSendMessageRequest(senderId int, receiverId int) (bool, error) {
+	if receiverId == 0 || receiverId == senderId {
+		return false, nil
+	}
+	blocked, err := IsBlocked(senderId, receiverId)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	status, err := GetMessageRequestStatus(senderId, receiverId)
+	if err != nil || status == MessageRequestDeclined {
+		return false, err
+	}
+	if status != "" {
+		return true, nil
+	}
+	incoming, err := GetMessageRequestStatus(receiverId, senderId)
+	if err != nil || incoming != "" {
+		return false, err
+	}
+
+	var setting string
+	var following bool
+	err = sqlite.DB.QueryRow(`
+		SELECT message_requests, EXISTS (SELECT 1 FROM followers WHERE follower_id = ? AND followed_id = users.id)
+		FROM users WHERE id = ?
+	`, senderId, receiverId).Scan(&setting, &following)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Error retrieving message request setting: %v", err)
+		return false, err
+	}
+	switch setting {
+	case MessageRequestsAnyone:
+		return true, nil
+	case MessageRequestsFollowers:
+		return following, nil
+	default:
+		return false, nil
+	}
+}
+
+// StartMessageRequest opens a pending message request from senderId to receiverId,
+// or keeps the existing one, so messages of the sender wait in the receiver's
+// requests. It returns false when the receiver does not accept requests from them.
+func StartMessageRequest(senderId int, receiverId int) (bool, error) {
+	allowed, err := CanSendMessageRequest(senderId, receiverId)
+	if err != nil || !allowed {
+		return false, err
+	}
+	_, err = sqlite.DB.Exec(`
+		INSERT INTO message_requests (sender_id, receiver_id, status) VALUES (?, ?, 'pending')
+		ON CONFLICT (sender_id, receiver_id) DO NOTHING
+	`, senderId, receiverId)
+	if err != nil {
+		log.Printf("Error creating message request: %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// RespondToMessageRequest accepts or declines the request senderId sent to receiverId.
+// A declined request can still be accepted later. It returns sql.ErrNoRows when
+// there is no such request.
+func RespondToMessageRequest(senderId int, receiverId int, status string) error {
+	result, err := sqlite.DB.Exec(`
+		UPDATE message_requests SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE sender_id = ? AND receiver_id = ? AND status != 'accepted'
+	`, status, senderId, receiverId)
+	if err != nil {
+		log.Printf("Error updating message request: %v", err)
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetMessageRequests returns the requests waiting for userId, or those they declined,
+// with their latest message, most recent first
+func GetMessageRequests(userId int, status string) ([]models.MessageRequest, error) {
+	rows, err := sqlite.DB.Query(`
+		SELECT r.status, r.created_at, u.id, u.username, u.avatar_url,
+			m.id, m.sender_id, m.receiver_id, m.content, m.created_at, m.edited_at, m.deleted_at
+		FROM message_requests r
+		JOIN users u ON u.id = r.sender_id
+		JOIN messages m ON m.id = (SELECT MAX(id) FROM messages WHERE sender_id = r.sender_id AND receiver_id = r.receiver_id)
+		WHERE r.receiver_id = ? AND r.status = ? AND NOT `+blockedUserCondition("r.sender_id")+`
+		ORDER BY m.id DESC
+	`, userId, status, userId, userId)
+	if err != nil {
+		log.Printf("Error retrieving message requests: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.MessageRequest{}
+	for rows.Next() {
+		var request models.MessageRequest
+		message := &request.LastMessage
+		if err := rows.Scan(&request.Status, &request.CreatedAt, &request.Sender.ID, &request.Sender.Username, &request.Sender.ProfileImg,
+			&message.ID, &message.SenderID, &message.ReceiverID, &message.Content, &message.CreatedAt, &message.EditedAt, &message.DeletedAt); err != nil {
+			log.Printf("Error scanning message request: %v", err)
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+// GetMessageRequestDirection tells userId how the private chat with partnerId stands:
+// "sent" while their own request is pending or declined, "received" while the
+// partner's is, or an empty string for a normal conversation.
+func GetMessageRequestDirection(userId int, partnerId int) (string, error) {
+	sent, err := GetMessageRequestStatus(userId, partnerId)
+	if err != nil {
+		return "", err
+	}
+	if sent == MessageRequestPending || sent == MessageRequestDeclined {
+		return "sent", nil
+	}
+	received, err := GetMessageRequestStatus(partnerId, userId)
+	if err != nil {
+		return "", err
+	}
+	if received == MessageRequestPending || received == MessageRequestDeclined {
+		return "received", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,192 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Private use characters marking the matches in snippets before they are escaped
+const (
+	highlightStart = "\uE000"
+	highlightEnd   = "\uE001"
+)
+
+// Messages the user can read: from their private chats and the groups they are an
+// accepted member of, unless deleted. ?1 is the user.
+const searchableMessages = `m.deleted_at IS NULL
+	AND ((m.sender_id = ?1 AND m.receiver_id IS NOT NULL) OR m.receiver_id = ?1
+		OR m.group_id IN (SELECT group_id FROM group_members WHERE user_id = ?1 AND status = 'accepted'))`
+
+// SearchMessages returns up to limit messages matching the search terms from the
+// conversations the user can read: their private chats and the groups they are an
+// accepted member of. The last term also matches as a prefix. With the FTS5 index
+// the best matches come first, without it terms match anywhere in words and the
+// latest messages come first.
+func SearchMessages(userId int, search string, limit int, offset int) ([]models.MessageSearchResult, bool, error) {
+	terms := strings.Fields(search)
+	if len(terms) == 0 {
+		return []models.MessageSearchResult{}, false, nil
+	}
+
+	var rows *sql.Rows
+	var err error
+	if sqlite.SearchIndexed {
+		rows, err = sqlite.DB.Query(`
+			SELECT m.id, m.sender_id, COALESCE(m.receiver_id, 0), COALESCE(m.group_id, 0), m.content, m.created_at, m.edited_at,
+				snippet(messages_fts, 0, ?4, ?5, '…', 16)
+			FROM messages_fts JOIN messages m ON m.id = messages_fts.rowid
+			WHERE messages_fts MATCH ?6 AND `+searchableMessages+`
+			ORDER BY rank
+			LIMIT ?2 OFFSET ?3
+		`, userId, limit+1, offset, highlightStart, highlightEnd, searchMatchExpression(terms))
+	} else {
+		args := []interface{}{userId, limit + 1, offset}
+		conditions := ""
+		for _, term := range terms {
+			args = append(args, "%"+likeEscaper.Replace(term)+"%")
+			conditions += fmt.Sprintf(" AND m.content LIKE ?%d ESCAPE '\\'", len(args))
+		}
+		rows, err = sqlite.DB.Query(`
+			SELECT m.id, m.sender_id, COALESCE(m.receiver_id, 0), COALESCE(m.group_id, 0), m.content, m.created_at, m.edited_at, m.content
+			FROM messages m
+			WHERE `+searchableMessages+conditions+`
+			ORDER BY m.id DESC
+			LIMIT ?2 OFFSET ?3
+		`, args...)
+	}
+	if err != nil {
+		log.Printf("Error searching messages: %v", err)
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	results := []models.MessageSearchResult{}
+	for rows.Next() {
+		var result models.MessageSearchResult
+		message := &result.Message
+		if err := rows.Scan(&message.ID, &message.SenderID, &message.ReceiverID, &message.GroupID, &message.Content, &message.CreatedAt, &message.EditedAt, &result.Highlight); err != nil {
+			log.Printf("Error scanning search result: %v", err)
+			return nil, false, err
+		}
+		if !sqlite.SearchIndexed {
+			result.Highlight = highlightTerms(result.Highlight, terms)
+		}
+		result.Highlight = strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>").Replace(html.EscapeString(result.Highlight))
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error during rows iteration: %v", err)
+		return nil, false, err
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+
+	// Resolve the conversation of each result, most share the same few
+	users := make(map[int]models.UserItem)
+	groups := make(map[int]models.Group)
+	for i := range results {
+		result := &results[i]
+		message := result.Message
+		if message.GroupID != 0 {
+			group, found := groups[message.GroupID]
+			if !found {
+				group, err = GetGroupQuery(message.GroupID)
+				if err != nil {
+					log.Printf("Error retrieving group data: %v", err)
+					return nil, false, err
+				}
+				groups[message.GroupID] = group
+			}
+			result.Group = &group
+			result.Link = fmt.Sprintf("/api/chat-group?groupId=%d&before=%d", message.GroupID, message.ID+1)
+			continue
+		}
+
+		userBId := message.ReceiverID
+		if message.ReceiverID == userId {
+			userBId = message.SenderID
+		}
+		userB, found := users[userBId]
+		if !found {
+			userB, err = GetUserItemByID(userBId)
+			if err != nil {
+				log.Printf("Error retrieving user: %v", err)
+				return nil, false, err
+			}
+			users[userBId] = userB
+		}
+		result.UserB = &userB
+		result.Link = fmt.Sprintf("/api/chat?userBName=%s&before=%d", url.QueryEscape(userB.Username), message.ID+1)
+	}
+
+	return results, hasMore, nil
+}
+
+// searchMatchExpression turns search terms into an FTS5 query matching every one,
+// quoting each so characters of the query syntax are searched literally.
+func searchMatchExpression(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	quoted[len(quoted)-1] += "*"
+	return strings.Join(quoted, " ")
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// highlightTerms marks where the terms appear in content, ignoring ASCII case as
+// LIKE does. It stands in for the snippet of the FTS5 index.
+func highlightTerms(content string, terms []string) string {
+	lower := asciiLower(content)
+	marked := make([]bool, len(content))
+	for _, term := range terms {
+		term = asciiLower(term)
+		for from := 0; from < len(lower); {
+			at := strings.Index(lower[from:], term)
+			if at < 0 {
+				break
+			}
+			for i := from + at; i < from+at+len(term); i++ {
+				marked[i] = true
+			}
+			from += at + len(term)
+		}
+	}
+
+	var highlighted strings.Builder
+	for i := 0; i < len(content); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			highlighted.WriteString(highlightStart)
+		}
+		if !marked[i] && i > 0 && marked[i-1] {
+			highlighted.WriteString(highlightEnd)
+		}
+		highlighted.WriteByte(content[i])
+	}
+	if len(content) > 0 && marked[len(content)-1] {
+		highlighted.WriteString(highlightEnd)
+	}
+	return highlighted.String()
+}
+
+// asciiLower lowercases the ASCII letters of s only, keeping its length
+func asciiLower(s string) string {
+	lower := []byte(s)
+	for i, c := range lower {
+		if 'A' <= c && c <= 'Z' {
+			lower[i] = c + 'a' - 'A'
+		}
+	}
+	return string(lower)
+}
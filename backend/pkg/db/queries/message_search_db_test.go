@@ -0,0 +1,94 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/db/sqlite/sqlitetest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestSearchMessagesOnlyInOwnConversations(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+	carol := sqlitetest.CreateUser(t, "carol")
+	dave := sqlitetest.CreateUser(t, "dave")
+
+	shared := createTestGroup(t, alice, bob)
+	private := createTestGroup(t, carol)
+	// Invited to the private group, alice has not joined it
+	if _, err := sqlite.DB.Exec("INSERT INTO group_members (group_id, user_id, inviter_id, status) VALUES (?, ?, ?, 'pending')", private, alice, carol); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceToBob := sendTestMessage(t, alice, bob, 0, "the secret plan")
+	bobToCarol := sendTestMessage(t, bob, carol, 0, "a secret for carol")
+	inShared := sendTestMessage(t, bob, 0, shared, "group secret")
+	inPrivate := sendTestMessage(t, carol, 0, private, "members only secret")
+	deleted := sendTestMessage(t, bob, alice, 0, "deleted secret")
+	if _, err := DeleteChatMessage(deleted, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		search string
+		want   []int
+	}{
+		{name: "private chat and joined group", userID: alice, search: "secret", want: []int{aliceToBob, inShared}},
+		{name: "both private chats", userID: bob, search: "secret", want: []int{aliceToBob, bobToCarol, inShared}},
+		{name: "member of the private group", userID: carol, search: "secret", want: []int{bobToCarol, inPrivate}},
+		{name: "no conversations", userID: dave, search: "secret", want: []int{}},
+		{name: "prefix of the last term", userID: alice, search: "the sec", want: []int{aliceToBob}},
+		{name: "other conversation's words", userID: alice, search: "carol", want: []int{}},
+		{name: "query syntax searched literally", userID: alice, search: `secret" OR "carol`, want: []int{}},
+		{name: "blank search", userID: bob, search: "  ", want: []int{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, hasMore, err := SearchMessages(test.userID, test.search, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasMore {
+				t.Error("hasMore set with fewer results than the limit")
+			}
+			got := []int{}
+			for _, result := range results {
+				got = append(got, result.Message.ID)
+				if (result.Group == nil) == (result.UserB == nil) {
+					t.Errorf("message %d has group %v and user %v, want exactly one", result.Message.ID, result.Group, result.UserB)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("found messages %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHighlightTerms(t *testing.T) {
+	tests := []struct {
+		content string
+		terms   []string
+		want    string
+	}{
+		{"the secret plan", []string{"secret"}, "the [secret] plan"},
+		{"Secret SECRET", []string{"secret"}, "[Secret] [SECRET]"},
+		{"the secret plan", []string{"the", "sec"}, "[the] [sec]ret plan"},
+		{"overlapping", []string{"over", "verl"}, "[overl]apping"},
+		{"café au lait", []string{"café"}, "[café] au lait"},
+		{"no match", []string{"other"}, "no match"},
+	}
+	for _, test := range tests {
+		got := strings.NewReplacer(highlightStart, "[", highlightEnd, "]").Replace(highlightTerms(test.content, test.terms))
+		if got != test.want {
+			t.Errorf("highlighting %v in %q = %q, want %q", test.terms, test.content, got, test.want)
+		}
+	}
+}
@@ -9,7 +9,14 @@ import (
 	"database/sql"
 )
 
+// CreateNotification stores a notification and returns its ID. Notifications between
+// users who blocked one another are dropped, and 0 is returned.
 func CreateNotification(notification models.Notification) (int64, error) {
+	blocked, err := IsBlocked(notification.NotifyingUserId, notification.NotifiedUserID)
+	if err != nil || blocked {
+		return 0, err
+	}
+
 	query := `
 		INSERT INTO notifications (notifiedUser_id, notifyingUser_id, type, object, object_id, content, is_read, created_at)
 		VALUES (?, ?, ?, NULLIF(?, 0), NULLIF(?, ''), ?, ?, ?)
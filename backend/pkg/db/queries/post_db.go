@@ -82,10 +82,10 @@ func GetPostsQuery(userID int) ([]models.Post, error) {
 			OR
 			-- User's own posts
 			p.user_id = ?
-		)
+		) AND NOT `+hiddenUserCondition("p.user_id")+`
 		ORDER BY p.created_at DESC
 	`
-	rows, err := sqlite.DB.Query(query, userID, userID, userID, userID)
+	rows, err := sqlite.DB.Query(query, userID, userID, userID, userID, userID, userID)
 	if err != nil {
 		log.Printf("Error retrieving posts: %v", err)
 		return nil, err
@@ -146,7 +146,7 @@ func GetSinglePostQuery(postID string, currentUserID int) (models.Post, error) {
 			(p.privacy = 'almost_private' AND pv.viewer_id IS NOT NULL AND p.group_id IS NULL) OR
 			(p.privacy = 'private' AND f.status = 'accepted' AND p.group_id IS NULL) OR
 			(p.group_id IS NOT NULL AND gm.status = 'accepted')
-		)
+		) AND NOT `+blockedUserCondition("p.user_id")+`
 	`
 	var post models.Post
 	var safeUser models.SafeUser
@@ -156,7 +156,7 @@ func GetSinglePostQuery(postID string, currentUserID int) (models.Post, error) {
 	var groupImageURL sql.NullString
 	var groupCreatedAt sql.NullTime
 
-	err := sqlite.DB.QueryRow(query, currentUserID, currentUserID, currentUserID, postID, currentUserID).Scan(
+	err := sqlite.DB.QueryRow(query, currentUserID, currentUserID, currentUserID, postID, currentUserID, currentUserID, currentUserID).Scan(
 		&post.ID, &post.Title, &post.Content, &imageURL, &post.Privacy, &post.CreatedAt,
 		&safeUser.ID, &safeUser.Username, &safeUser.AvatarURL,
 		&groupID, &groupName, &groupDescription, &groupCreatorID, &groupImageURL, &groupCreatedAt,
@@ -181,10 +181,10 @@ func GetSinglePostQuery(postID string, currentUserID int) (models.Post, error) {
 		SELECT c.id, c.content, c.created_at, u.id, u.username, u.avatar_url
 		FROM comments c
 		JOIN users u ON c.user_id = u.id
-		WHERE c.post_id = ?
+		WHERE c.post_id = ? AND NOT `+hiddenUserCondition("c.user_id")+`
 		ORDER BY c.created_at DESC
 	`
-	rows, err := sqlite.DB.Query(commentsQuery, postID)
+	rows, err := sqlite.DB.Query(commentsQuery, postID, currentUserID, currentUserID)
 	if err != nil {
 		log.Printf("Error retrieving comments for post: %v", err)
 		return post, err
@@ -222,7 +222,7 @@ func GetFollowedPostsQuery(userID int) ([]models.Post, error) {
         LEFT JOIN group_members gm ON gm.group_id = p.group_id AND gm.user_id = ?
         LEFT JOIN followers f ON f.followed_id = p.user_id AND f.follower_id = ?
         LEFT JOIN post_viewers pv ON pv.post_id = p.id AND pv.viewer_id = ?
-        WHERE 
+        WHERE (
             (p.user_id = ?) OR  -- User's own posts
             (f.status = 'accepted' AND (
                 (p.privacy = 'public' AND p.group_id IS NULL) OR
@@ -230,9 +230,10 @@ func GetFollowedPostsQuery(userID int) ([]models.Post, error) {
                 (p.privacy = 'almost_private' AND pv.viewer_id IS NOT NULL AND p.group_id IS NULL) OR
                 (p.group_id IS NOT NULL AND gm.status = 'accepted')
             ))
+        ) AND NOT `+hiddenUserCondition("p.user_id")+`
         ORDER BY p.created_at DESC
     `
-	rows, err := sqlite.DB.Query(query, userID, userID, userID, userID)
+	rows, err := sqlite.DB.Query(query, userID, userID, userID, userID, userID, userID)
 	if err != nil {
 		log.Printf("Error retrieving followed posts: %v", err)
 		return nil, err
@@ -268,10 +269,10 @@ func GetUserPostsQuery(targetUserID, currentUserID int) ([]models.Post, error) {
 			OR
 			-- All posts if the current user is viewing their own profile
 			? = ?
-		)
+		) AND NOT `+blockedUserCondition("p.user_id")+`
 		ORDER BY p.created_at DESC
 	`
-	rows, err := sqlite.DB.Query(query, currentUserID, currentUserID, currentUserID, targetUserID, currentUserID, targetUserID)
+	rows, err := sqlite.DB.Query(query, currentUserID, currentUserID, currentUserID, targetUserID, currentUserID, targetUserID, currentUserID, currentUserID)
 	if err != nil {
 		log.Printf("Error retrieving user posts: %v", err)
 		return nil, err
@@ -324,6 +325,7 @@ func scanPosts(rows *sql.Rows) ([]models.Post, error) {
 func IsUserPermittedToViewPost(postID int, userID int) (bool, error) {
 	query := `
 		SELECT CASE
+			WHEN `+blockedUserCondition("p.user_id")+` THEN FALSE
 			WHEN p.user_id = ? THEN TRUE
 			WHEN p.privacy = 'public' AND u.is_public = TRUE AND p.group_id IS NULL THEN TRUE
 			WHEN p.privacy = 'private' AND EXISTS (
@@ -342,7 +344,7 @@ func IsUserPermittedToViewPost(postID int, userID int) (bool, error) {
 		WHERE p.id = ?
 	`
 	var isPermitted bool
-	err := sqlite.DB.QueryRow(query, userID, userID, userID, userID, postID).Scan(&isPermitted)
+	err := sqlite.DB.QueryRow(query, userID, userID, userID, userID, userID, userID, postID).Scan(&isPermitted)
 	if err != nil {
 		return false, fmt.Errorf("error checking post permissions: %w", err)
 	}
@@ -400,7 +402,7 @@ func CreateGroupPostQuery(newPost models.Post) (int64, error) {
 }
 
 // Add this function to fetch group posts
-func GetGroupPostsQuery(groupID int) ([]models.Post, error) {
+func GetGroupPostsQuery(groupID int, viewerID int) ([]models.Post, error) {
 	query := `
 		SELECT p.id, p.title, p.content, p.image_url, p.privacy, p.created_at, 
 			   u.id, u.username, u.avatar_url,
@@ -408,10 +410,10 @@ func GetGroupPostsQuery(groupID int) ([]models.Post, error) {
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
 		LEFT JOIN groups g ON p.group_id = g.id
-		WHERE p.group_id = ?
+		WHERE p.group_id = ? AND NOT `+hiddenUserCondition("p.user_id")+`
 		ORDER BY p.created_at DESC
 	`
-	rows, err := sqlite.DB.Query(query, groupID)
+	rows, err := sqlite.DB.Query(query, groupID, viewerID, viewerID)
 	if err != nil {
 		log.Printf("Error retrieving group posts: %v", err)
 		return nil, err
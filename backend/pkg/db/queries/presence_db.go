@@ -0,0 +1,115 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// UpdateLastSeen records the current time as the user's last activity
+func UpdateLastSeen(userID int) error {
+	_, err := sqlite.DB.Exec("UPDATE users SET last_seen_at = ? WHERE id = ?", time.Now(), userID)
+	if err != nil {
+		log.Printf("Error updating last seen: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetLastSeen returns when the user was last seen and whether they share their status
+func GetLastSeen(userID int) (*time.Time, bool, error) {
+	var lastSeen sql.NullTime
+	var showPresence bool
+	err := sqlite.DB.QueryRow("SELECT last_seen_at, show_presence FROM users WHERE id = ?", userID).Scan(&lastSeen, &showPresence)
+	if err != nil {
+		log.Printf("Error retrieving last seen: %v", err)
+		return nil, false, err
+	}
+	if !lastSeen.Valid {
+		return nil, showPresence, nil
+	}
+	return &lastSeen.Time, showPresence, nil
+}
+
+// SetShowPresence changes whether the user's online status is visible to others,
+// reporting whether it was set the other way before
+func SetShowPresence(userID int, showPresence bool) (bool, error) {
+	result, err := sqlite.DB.Exec("UPDATE users SET show_presence = ? WHERE id = ? AND show_presence != ?", showPresence, userID, showPresence)
+	if err != nil {
+		log.Printf("Error updating presence setting: %v", err)
+		return false, err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Error updating presence setting: %v", err)
+		return false, err
+	}
+	return changed > 0, nil
+}
+
+// GetPresenceAudience returns the users told when userID goes online or offline:
+// accepted followers and followings, and private chat partners unless their conversation
+// is held in a message request, except blocked users
+func GetPresenceAudience(userID int) ([]int, error) {
+	rows, err := sqlite.DB.Query(`
+		SELECT id FROM (
+			SELECT follower_id AS id FROM followers WHERE followed_id = ? AND status = 'accepted'
+			UNION
+			SELECT followed_id FROM followers WHERE follower_id = ? AND status = 'accepted'
+			UNION
+			SELECT m.sender_id FROM messages m WHERE m.receiver_id = ? AND NOT `+heldMessageRequestCondition("m.sender_id", "m.receiver_id")+`
+			UNION
+			SELECT m.receiver_id FROM messages m WHERE m.sender_id = ? AND m.receiver_id IS NOT NULL AND NOT `+heldMessageRequestCondition("m.sender_id", "m.receiver_id")+`
+		)
+		WHERE NOT `+blockedUserCondition("id")+`
+	`, userID, userID, userID, userID, userID, userID)
+	if err != nil {
+		log.Printf("Error retrieving presence audience: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var audience []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Error scanning presence audience row: %v", err)
+			return nil, err
+		}
+		audience = append(audience, id)
+	}
+	return audience, nil
+}
+
+// CanSeePresence checks whether viewerID may see the status of userID: they follow
+// each other in either direction, have chatted outside of a pending or declined message
+// request, or share a group, and neither blocked the other
+func CanSeePresence(viewerID int, userID int) (bool, error) {
+	if viewerID == userID {
+		return true, nil
+	}
+	var related bool
+	err := sqlite.DB.QueryRow(`
+		SELECT NOT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE type = 'block' AND ((blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1))
+		) AND (EXISTS (
+			SELECT 1 FROM followers
+			WHERE status = 'accepted' AND ((follower_id = $1 AND followed_id = $2) OR (follower_id = $2 AND followed_id = $1))
+		) OR EXISTS (
+			SELECT 1 FROM messages
+			WHERE ((sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1))
+				AND NOT `+heldMessageRequestCondition("$1", "$2")+`
+		) OR EXISTS (
+			SELECT 1 FROM group_members a
+			JOIN group_members b ON a.group_id = b.group_id
+			WHERE a.user_id = $1 AND b.user_id = $2 AND a.status = 'accepted' AND b.status = 'accepted'
+		))
+	`, viewerID, userID).Scan(&related)
+	if err != nil {
+		log.Printf("Error checking presence visibility: %v", err)
+		return false, err
+	}
+	return related, nil
+}
@@ -0,0 +1,96 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/db/sqlite/sqlitetest"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestPresenceOnlyForAcceptedConversations(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	pending := sqlitetest.CreateUser(t, "pending")
+	declined := sqlitetest.CreateUser(t, "declined")
+	accepted := sqlitetest.CreateUser(t, "accepted")
+	follower := sqlitetest.CreateUser(t, "follower")
+	chatted := sqlitetest.CreateUser(t, "chatted") // Chatted while following, without a request
+	stranger := sqlitetest.CreateUser(t, "stranger")
+
+	requests := map[int]string{pending: MessageRequestPending, declined: MessageRequestDeclined, accepted: MessageRequestAccepted}
+	for senderID, status := range requests {
+		if _, err := sqlite.DB.Exec("INSERT INTO message_requests (sender_id, receiver_id, status) VALUES (?, ?, ?)", senderID, alice, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, senderID := range []int{pending, declined, accepted, chatted} {
+		sendTestMessage(t, senderID, alice, 0, "hello")
+	}
+	// alice answering does not accept the request
+	sendTestMessage(t, alice, pending, 0, "who are you?")
+	if err := FollowUser(follower, alice, "accepted"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAudience := func(want []int) {
+		t.Helper()
+		audience, err := GetPresenceAudience(alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(audience)
+		if !slices.Equal(audience, want) {
+			t.Errorf("presence audience of alice is %v, want %v", audience, want)
+		}
+		for _, viewerID := range []int{pending, declined, accepted, follower, chatted, stranger} {
+			canSee, err := CanSeePresence(viewerID, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := slices.Contains(want, viewerID); canSee != want {
+				t.Errorf("user %d can see the presence of alice: %v, want %v", viewerID, canSee, want)
+			}
+		}
+	}
+	checkAudience([]int{accepted, follower, chatted})
+
+	// Following each other lets them chat, the requests stop holding the conversation back
+	if err := FollowUser(alice, pending, "accepted"); err != nil {
+		t.Fatal(err)
+	}
+	checkAudience([]int{pending, accepted, follower, chatted})
+	// A follow request only counts once accepted
+	if err := FollowUser(declined, alice, "pending"); err != nil {
+		t.Fatal(err)
+	}
+	checkAudience([]int{pending, accepted, follower, chatted})
+	if err := AcceptFollowRequest(declined, alice); err != nil {
+		t.Fatal(err)
+	}
+	checkAudience([]int{pending, declined, accepted, follower, chatted})
+
+	for senderID := range requests {
+		status, err := GetMessageRequestStatus(senderID, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != MessageRequestAccepted {
+			t.Errorf("request of user %d is %s once they follow each other, want %s", senderID, status, MessageRequestAccepted)
+		}
+		direction, err := GetMessageRequestDirection(alice, senderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if direction != "" {
+			t.Errorf("chat with user %d is a %s request, want a normal conversation", senderID, direction)
+		}
+	}
+	chats, err := GetLastMessageOfAllChatQuery(alice, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chats) != 4 {
+		t.Errorf("alice has %d conversations, want 4", len(chats))
+	}
+}
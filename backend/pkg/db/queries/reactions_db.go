@@ -108,3 +108,16 @@ func GetUserReaction(userID int, postID, commentID *int) (*models.Reaction, erro
 	}
 	return &reaction, nil
 }
+
+// GetReactionTypeByID retrieves a single reaction type, sql.ErrNoRows when it does not exist
+func GetReactionTypeByID(reactionTypeID int) (models.ReactionType, error) {
+	var r models.ReactionType
+	err := sqlite.DB.QueryRow(`SELECT id, name, icon_url FROM reaction_types WHERE id = ?`, reactionTypeID).Scan(&r.ID, &r.Name, &r.IconURL)
+	if err == sql.ErrNoRows {
+		return models.ReactionType{}, err
+	}
+	if err != nil {
+		return models.ReactionType{}, fmt.Errorf("error querying reaction type: %v", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,232 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Types of the entries in the security log
+const (
+	EventLoginSucceeded       = "login_succeeded"
+	EventLoginFailed          = "login_failed"
+	EventTwoFactorFailed      = "two_factor_failed"
+	EventAccountLocked        = "account_locked"
+	EventIPLocked             = "ip_locked"
+	EventSignupLocked         = "signup_locked"
+	EventEmailLocked          = "email_locked"
+	EventAccountUnlocked      = "account_unlocked"
+	EventPasswordReset        = "password_reset"
+	EventTwoFactorEnabled     = "two_factor_enabled"
+	EventTwoFactorDisabled    = "two_factor_disabled"
+	EventRecoveryCodesRenewed = "recovery_codes_regenerated"
+)
+
+// Throttle limits how often something may be attempted. The first FreeAttempts go
+// through, then every attempt locks the key for BaseDelay, doubled at each further
+// attempt up to MaxDelay. The count starts over after Window without attempts.
+type Throttle struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+}
+
+var (
+	// Wrong passwords or codes for one account, whether it was named by username or email
+	AccountLoginThrottle = Throttle{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Window: 24 * time.Hour}
+	// Wrong passwords or codes from one IP address, for any account
+	IPLoginThrottle = Throttle{FreeAttempts: 20, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 24 * time.Hour}
+	// Signups from one IP address, successful or not
+	SignupThrottle = Throttle{FreeAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 24 * time.Hour}
+	// Password reset and verification emails asked for one address, whether it has an account or not
+	EmailThrottle = Throttle{FreeAttempts: 3, BaseDelay: 5 * time.Minute, MaxDelay: time.Hour, Window: 24 * time.Hour}
+	// Password reset and verification emails asked for from one IP address
+	IPEmailThrottle = Throttle{FreeAttempts: 10, BaseDelay: 5 * time.Minute, MaxDelay: time.Hour, Window: 24 * time.Hour}
+)
+
+// delay returns how long the key is locked after its nth attempt
+func (t Throttle) delay(attempts int) time.Duration {
+	if attempts <= t.FreeAttempts {
+		return 0
+	}
+	delay := t.BaseDelay
+	for i := t.FreeAttempts + 1; i < attempts && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay
+}
+
+// Attempt is something attempted against a throttled key
+type Attempt struct {
+	Key      string
+	Throttle Throttle
+}
+
+// TakeAttempts counts an attempt against each of the keys, unless any of them is
+// locked. Checking and counting happen in one transaction holding the database's
+// write lock, so parallel attempts cannot all get through before the first is counted.
+// When refused it returns until when the key locked the longest is locked. Otherwise
+// it returns until when each key is now locked, the zero time for those that are not.
+func TakeAttempts(attempts ...Attempt) (time.Time, []time.Time, error) {
+	ctx := context.Background()
+	conn, err := sqlite.DB.Conn(ctx)
+	if err != nil {
+		log.Printf("Error getting a database connection: %v", err)
+		return time.Time{}, nil, err
+	}
+	defer conn.Close()
+
+	// A deferred transaction would only take the write lock at its first write, after
+	// another attempt may have read the same counts
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return time.Time{}, nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(ctx, "ROLLBACK")
+		}
+	}()
+
+	now := time.Now()
+	type counted struct {
+		attempts      int
+		lastAttemptAt time.Time
+	}
+	counts := make([]counted, len(attempts))
+	var refusedUntil time.Time
+	for i, attempt := range attempts {
+		var lockedUntil sql.NullTime
+		err := conn.QueryRowContext(ctx, "SELECT attempts, last_attempt_at, locked_until FROM auth_throttles WHERE key = ?", attempt.Key).
+			Scan(&counts[i].attempts, &counts[i].lastAttemptAt, &lockedUntil)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Error retrieving attempts: %v", err)
+			return time.Time{}, nil, err
+		}
+		if lockedUntil.Valid && lockedUntil.Time.After(now) && lockedUntil.Time.After(refusedUntil) {
+			refusedUntil = lockedUntil.Time
+		}
+	}
+	if !refusedUntil.IsZero() {
+		return refusedUntil, nil, nil
+	}
+
+	lockouts := make([]time.Time, len(attempts))
+	for i, attempt := range attempts {
+		total := counts[i].attempts
+		if now.Sub(counts[i].lastAttemptAt) > attempt.Throttle.Window {
+			total = 0
+		}
+		total++
+
+		if delay := attempt.Throttle.delay(total); delay > 0 {
+			lockouts[i] = now.Add(delay)
+		}
+		_, err = conn.ExecContext(ctx, `
+			INSERT INTO auth_throttles (key, attempts, last_attempt_at, locked_until) VALUES (?1, ?2, ?3, ?4)
+			ON CONFLICT(key) DO UPDATE SET attempts = ?2, last_attempt_at = ?3, locked_until = ?4
+		`, attempt.Key, total, now, sql.NullTime{Time: lockouts[i], Valid: !lockouts[i].IsZero()})
+		if err != nil {
+			log.Printf("Error recording attempt: %v", err)
+			return time.Time{}, nil, err
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return time.Time{}, nil, err
+	}
+	committed = true
+	return time.Time{}, lockouts, nil
+}
+
+// RefundAttempt takes back an attempt counted by TakeAttempts that turned out to be
+// legitimate, along with the lockout it caused, lockedUntil. A lockout set since by
+// another attempt is kept.
+func RefundAttempt(key string, lockedUntil time.Time) error {
+	_, err := sqlite.DB.Exec(`
+		UPDATE auth_throttles SET attempts = MAX(attempts - 1, 0),
+			locked_until = CASE WHEN locked_until = ? THEN NULL ELSE locked_until END
+		WHERE key = ?
+	`, sql.NullTime{Time: lockedUntil, Valid: !lockedUntil.IsZero()}, key)
+	if err != nil {
+		log.Printf("Error refunding attempt: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ClearAttempts forgets the attempts of the keys, lifting their lockouts
+func ClearAttempts(keys ...string) error {
+	for _, key := range keys {
+		if _, err := sqlite.DB.Exec("DELETE FROM auth_throttles WHERE key = ?", key); err != nil {
+			log.Printf("Error clearing attempts: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// LogSecurityEvent adds an entry to the security log. Failing to do so is logged but
+// never stops the request the event is about.
+func LogSecurityEvent(event models.SecurityEvent) {
+	log.Printf("Security event %s: user=%d identifier=%q ip=%s %s",
+		event.Type, event.UserID, event.Identifier, event.IPAddress, event.Details)
+
+	_, err := sqlite.DB.Exec(`
+		INSERT INTO security_events (user_id, event_type, identifier, ip_address, user_agent, details)
+		VALUES (NULLIF(?, 0), ?, NULLIF(?, ''), ?, ?, NULLIF(?, ''))
+	`, event.UserID, event.Type, event.Identifier, event.IPAddress, event.UserAgent, event.Details)
+	if err != nil {
+		log.Printf("Error logging security event: %v", err)
+	}
+}
+
+// GetSecurityEvents returns the latest entries of the security log, only those of
+// the user when userID is not 0
+func GetSecurityEvents(userID int, limit int) ([]models.SecurityEvent, error) {
+	rows, err := sqlite.DB.Query(`
+		SELECT id, COALESCE(user_id, 0), event_type, COALESCE(identifier, ''), COALESCE(ip_address, ''),
+			COALESCE(user_agent, ''), COALESCE(details, ''), created_at
+		FROM security_events
+		WHERE ?1 = 0 OR user_id = ?1
+		ORDER BY id DESC
+		LIMIT ?2
+	`, userID, limit)
+	if err != nil {
+		log.Printf("Error retrieving security events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.SecurityEvent{}
+	for rows.Next() {
+		var event models.SecurityEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.Identifier, &event.IPAddress,
+			&event.UserAgent, &event.Details, &event.CreatedAt); err != nil {
+			log.Printf("Error scanning security event: %v", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// IsAdmin checks whether the user is a site administrator
+func IsAdmin(userID int) (bool, error) {
+	var admin bool
+	err := sqlite.DB.QueryRow("SELECT is_admin FROM users WHERE id = ?", userID).Scan(&admin)
+	if err != nil {
+		log.Printf("Error checking admin: %v", err)
+		return false, err
+	}
+	return admin, nil
+}
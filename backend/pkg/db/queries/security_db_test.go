@@ -0,0 +1,202 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/db/sqlite/sqlitetest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottleDelay(t *testing.T) {
+	tests := []struct {
+		throttle Throttle
+		attempts int
+		want     time.Duration
+	}{
+		{AccountLoginThrottle, 1, 0},
+		{AccountLoginThrottle, 5, 0},
+		{AccountLoginThrottle, 6, 30 * time.Second},
+		{AccountLoginThrottle, 7, time.Minute},
+		{AccountLoginThrottle, 8, 2 * time.Minute},
+		{AccountLoginThrottle, 10, 8 * time.Minute},
+		{AccountLoginThrottle, 11, 15 * time.Minute},
+		{AccountLoginThrottle, 1000, 15 * time.Minute},
+		{IPLoginThrottle, 20, 0},
+		{IPLoginThrottle, 21, time.Minute},
+		{IPLoginThrottle, 26, 32 * time.Minute},
+		{IPLoginThrottle, 27, time.Hour},
+		{EmailThrottle, 3, 0},
+		{EmailThrottle, 4, 5 * time.Minute},
+		{EmailThrottle, 7, 40 * time.Minute},
+		{EmailThrottle, 8, time.Hour},
+		{Throttle{FreeAttempts: 0, BaseDelay: time.Second, MaxDelay: time.Second}, 1, time.Second},
+	}
+	for _, test := range tests {
+		if got := test.throttle.delay(test.attempts); got != test.want {
+			t.Errorf("%+v after %d attempts: delay %v, want %v", test.throttle, test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestTakeAttempts(t *testing.T) {
+	sqlitetest.Open(t)
+	throttle := Throttle{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: 3 * time.Minute, Window: time.Hour}
+
+	// expire ends the lockout of the key, as if it had run out
+	expire := func(key string) {
+		t.Helper()
+		if _, err := sqlite.DB.Exec("UPDATE auth_throttles SET locked_until = ? WHERE key = ?", time.Now().Add(-time.Second), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// idle makes the last attempt of the key older than the window
+	idle := func(key string) {
+		t.Helper()
+		if _, err := sqlite.DB.Exec("UPDATE auth_throttles SET last_attempt_at = ?, locked_until = NULL WHERE key = ?", time.Now().Add(-throttle.Window-time.Second), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name    string
+		key     string
+		clear   []string // Keys cleared before the attempt
+		expire  bool     // The lockout of the key runs out before the attempt
+		idle    bool     // The key is left alone past the window before the attempt
+		refused time.Duration
+		want    time.Duration
+	}{
+		{name: "first attempt", key: "account:1"},
+		{name: "last free attempt", key: "account:1"},
+		{name: "first lockout", key: "account:1", want: time.Minute},
+		{name: "while locked", key: "account:1", refused: time.Minute},
+		{name: "still locked", key: "account:1", refused: time.Minute},
+		{name: "doubled", key: "account:1", expire: true, want: 2 * time.Minute},
+		{name: "capped", key: "account:1", expire: true, want: 3 * time.Minute},
+		{name: "other key", key: "ip:127.0.0.1"},
+		{name: "after clearing", key: "account:1", clear: []string{"account:1", "ip:127.0.0.1"}},
+		{name: "count restarted", key: "account:1"},
+		{name: "locked again", key: "account:1", want: time.Minute},
+		{name: "after the window", key: "account:1", idle: true},
+		{name: "other key cleared", key: "ip:127.0.0.1"},
+	}
+	for _, step := range steps {
+		if err := ClearAttempts(step.clear...); err != nil {
+			t.Fatal(err)
+		}
+		if step.expire {
+			expire(step.key)
+		}
+		if step.idle {
+			idle(step.key)
+		}
+
+		before := time.Now()
+		refusedUntil, lockouts, err := TakeAttempts(Attempt{Key: step.key, Throttle: throttle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step.refused != 0 {
+			// Refused attempts are not counted, the lockout stays the same
+			if refusedUntil.IsZero() || lockouts != nil {
+				t.Fatalf("%s: attempt allowed, want it refused", step.name)
+			}
+			if wait := refusedUntil.Sub(before); wait > step.refused {
+				t.Errorf("%s: refused for %v, want at most %v", step.name, wait, step.refused)
+			}
+			continue
+		}
+		if !refusedUntil.IsZero() {
+			t.Fatalf("%s: refused until %v", step.name, refusedUntil)
+		}
+		if step.want == 0 {
+			if !lockouts[0].IsZero() {
+				t.Errorf("%s: locked until %v, want no lockout", step.name, lockouts[0])
+			}
+			continue
+		}
+		if delay := lockouts[0].Sub(before); delay < step.want || delay > step.want+time.Second {
+			t.Errorf("%s: locked for %v, want %v", step.name, delay, step.want)
+		}
+	}
+}
+
+func TestTakeAttemptsOverSeveralKeys(t *testing.T) {
+	sqlitetest.Open(t)
+	throttle := Throttle{FreeAttempts: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	account := Attempt{Key: "account:1", Throttle: throttle}
+	ip := Attempt{Key: "ip:127.0.0.1", Throttle: Throttle{FreeAttempts: 10, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}}
+	attempts := func(key string) int {
+		t.Helper()
+		var count int
+		if err := sqlite.DB.QueryRow("SELECT COALESCE(SUM(attempts), 0) FROM auth_throttles WHERE key = ?", key).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	steps := []struct {
+		name       string
+		refund     bool // The attempt turns out legitimate
+		refused    bool
+		accountWas int // Attempts counted against the keys afterwards
+		ipWas      int
+	}{
+		{name: "free attempt", accountWas: 1, ipWas: 1},
+		{name: "locking attempt that succeeds", refund: true, accountWas: 1, ipWas: 1},
+		{name: "locking attempt that fails", accountWas: 2, ipWas: 2},
+		{name: "refused by the account", refused: true, accountWas: 2, ipWas: 2},
+	}
+	for _, step := range steps {
+		refusedUntil, lockouts, err := TakeAttempts(account, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refused := !refusedUntil.IsZero(); refused != step.refused {
+			t.Fatalf("%s: refused = %v, want %v", step.name, refused, step.refused)
+		}
+		if step.refund {
+			for i, attempt := range []Attempt{account, ip} {
+				if err := RefundAttempt(attempt.Key, lockouts[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if got := attempts(account.Key); got != step.accountWas {
+			t.Errorf("%s: %d attempts counted against the account, want %d", step.name, got, step.accountWas)
+		}
+		if got := attempts(ip.Key); got != step.ipWas {
+			t.Errorf("%s: %d attempts counted against the IP address, want %d", step.name, got, step.ipWas)
+		}
+	}
+}
+
+func TestTakeAttemptsInParallel(t *testing.T) {
+	sqlitetest.Open(t)
+	throttle := Throttle{FreeAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+
+	// Guesses sent at once get no more than the free attempts and the one locking the key
+	const guesses = 30
+	var wg sync.WaitGroup
+	var allowed int64
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refusedUntil, _, err := TakeAttempts(Attempt{Key: "account:1", Throttle: throttle})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if refusedUntil.IsZero() {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != int64(throttle.FreeAttempts+1) {
+		t.Errorf("%d of %d parallel guesses allowed, want %d", allowed, guesses, throttle.FreeAttempts+1)
+	}
+}
@@ -10,28 +10,53 @@ import (
 	"github.com/gofrs/uuid"
 )
 
-// CreateSession creates a new session for a user and returns the session ID
-func CreateSession(userID int) (string, error) {
+// Session lifetimes. A session expires once unused for SessionIdleTimeout, and at the
+// latest SessionAbsoluteTimeout after login. A "remember me" session instead lasts
+// SessionRememberMeTimeout whether used or not. main may override them at startup.
+var (
+	SessionIdleTimeout       = 24 * time.Hour
+	SessionAbsoluteTimeout   = 7 * 24 * time.Hour
+	SessionRememberMeTimeout = 30 * 24 * time.Hour
+)
+
+// CreateSession creates a new session for a user on the device described by userAgent
+// and ipAddress. It returns the session ID and the time the session expires at the
+// latest, for the cookie. Other sessions of the user are kept.
+func CreateSession(userID int, userAgent string, ipAddress string, rememberMe bool) (string, time.Time, error) {
 	sessionID, err := uuid.NewV4()
 	if err != nil {
 		log.Printf("Error generating UUID: %v", err)
-		return "", err
+		return "", time.Time{}, err
+	}
+	publicID, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("Error generating UUID: %v", err)
+		return "", time.Time{}, err
 	}
 
-	expiresAt := time.Now().Add(24 * time.Hour)
+	now := time.Now()
+	absoluteExpiresAt := now.Add(SessionAbsoluteTimeout)
+	expiresAt := now.Add(SessionIdleTimeout)
+	if rememberMe {
+		absoluteExpiresAt = now.Add(SessionRememberMeTimeout)
+		expiresAt = absoluteExpiresAt
+	}
+	if expiresAt.After(absoluteExpiresAt) {
+		expiresAt = absoluteExpiresAt
+	}
 
 	log.Printf("Creating session for user ID: %d", userID) // Add this line
 
 	_, err = sqlite.DB.Exec(`
-		INSERT OR REPLACE INTO sessions (id, user_id, expires_at) 
-		VALUES (?, ?, ?)
-	`, sessionID.String(), userID, expiresAt)
+		INSERT INTO sessions (id, public_id, user_id, user_agent, ip_address, last_used_at, expires_at, absolute_expires_at, remember_me)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, sessionID.String(), publicID.String(), userID, userAgent, ipAddress, now, expiresAt, absoluteExpiresAt, rememberMe)
 	if err != nil {
 		log.Printf("Error creating session: %v", err)
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	return sessionID.String(), nil
+	return sessionID.String(), absoluteExpiresAt, nil
 }
 
 // DeleteSession removes a session from the database
@@ -59,10 +84,23 @@ func GetUserIDBySession(sessionID string) (int, error) {
 	return userID, nil
 }
 
-// RefreshSession extends the expiration time of a session
-func RefreshSession(sessionID string) error {
-	newExpiresAt := time.Now().Add(24 * time.Hour)
-	_, err := sqlite.DB.Exec("UPDATE sessions SET expires_at = ? WHERE id = ?", newExpiresAt, sessionID)
+// sessionRefreshInterval is how stale a session may get before a request refreshes it,
+// so that not every request writes to the sessions table. It stays a small part of
+// the idle timeout, for short timeouts to still slide.
+func sessionRefreshInterval() time.Duration {
+	return min(time.Minute, SessionIdleTimeout/60)
+}
+
+// RefreshSession records that the session was just used from ipAddress, and slides
+// its expiry to SessionIdleTimeout from now, never past its absolute expiry.
+// Expired sessions are left alone.
+func RefreshSession(sessionID string, ipAddress string) error {
+	now := time.Now()
+	_, err := sqlite.DB.Exec(`
+		UPDATE sessions SET last_used_at = ?1, ip_address = ?2,
+			expires_at = CASE WHEN remember_me THEN expires_at ELSE MIN(?3, COALESCE(absolute_expires_at, ?3)) END
+		WHERE id = ?4 AND expires_at > ?1 AND (last_used_at IS NULL OR last_used_at < ?5 OR ip_address != ?2)
+	`, now, ipAddress, now.Add(SessionIdleTimeout), sessionID, now.Add(-sessionRefreshInterval()))
 	if err != nil {
 		log.Printf("Error refreshing session: %v", err)
 		return err
@@ -80,6 +118,19 @@ func DeleteExpiredSessions() error {
 	return nil
 }
 
+// SweepExpiredSessions deletes the expired sessions and login challenges, and the
+// real-time events past their retention, every interval. It never returns and is meant to run in its own goroutine.
+func SweepExpiredSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// Errors are logged by the queries, the next sweep tries again
+		DeleteExpiredSessions()
+		DeleteExpiredLoginChallenges()
+		PruneUserEvents()
+	}
+}
+
 // IsSessionValid checks if a session is valid and not expired
 func IsSessionValid(sessionID string) (bool, error) {
 	var count int
@@ -91,14 +142,75 @@ func IsSessionValid(sessionID string) (bool, error) {
 	return count > 0, nil
 }
 
-// RemoveExistingSession removes any existing session for a user
-func RemoveExistingSession(userID int) error {
-	_, err := sqlite.DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+// GetUserSessions returns the active sessions of a user, most recently used first.
+// The one with the ID currentSessionID is flagged as current.
+func GetUserSessions(userID int, currentSessionID string) ([]models.Session, error) {
+	rows, err := sqlite.DB.Query(`
+		SELECT id, public_id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+	`, userID, time.Now())
 	if err != nil {
-		log.Printf("Error removing existing session: %v", err)
-		return err
+		log.Printf("Error retrieving sessions: %v", err)
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var sessionID string
+		var lastUsedAt *time.Time
+		var session models.Session
+		if err := rows.Scan(&sessionID, &session.ID, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &lastUsedAt, &session.ExpiresAt); err != nil {
+			log.Printf("Error scanning session: %v", err)
+			return nil, err
+		}
+		session.LastUsedAt = session.CreatedAt
+		if lastUsedAt != nil {
+			session.LastUsedAt = *lastUsedAt
+		}
+		session.Current = sessionID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession deletes the session of the user with the given public ID and returns
+// its session ID, so its connections can be closed. It returns sql.ErrNoRows when
+// the user has no such session.
+func RevokeSession(userID int, publicID string) (string, error) {
+	var sessionID string
+	err := sqlite.DB.QueryRow("DELETE FROM sessions WHERE user_id = ? AND public_id = ? RETURNING id", userID, publicID).Scan(&sessionID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error revoking session: %v", err)
+		}
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// RevokeOtherSessions deletes every session of the user except keepSessionID, all of
+// them when it is empty, and returns the IDs of the deleted sessions
+func RevokeOtherSessions(userID int, keepSessionID string) ([]string, error) {
+	rows, err := sqlite.DB.Query("DELETE FROM sessions WHERE user_id = ? AND id != ? RETURNING id", userID, keepSessionID)
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			log.Printf("Error scanning revoked session: %v", err)
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	return sessionIDs, rows.Err()
 }
 
 // GetSessionUser retrieves the user associated with a given session ID
@@ -107,13 +219,14 @@ func GetSessionUser(sessionID string) (*models.User, error) {
 	var nickname, aboutMe, avatarURL sql.NullString
 
 	err := sqlite.DB.QueryRow(`
-		SELECT u.id, u.username, u.email, u.first_name, u.last_name, u.nickname, u.date_of_birth, u.about_me, u.avatar_url
+		SELECT u.id, u.username, u.email, u.first_name, u.last_name, u.nickname, u.date_of_birth, u.about_me, u.avatar_url,
+			u.email_verified_at IS NOT NULL, u.totp_enabled_at IS NOT NULL
 		FROM users u
 		JOIN sessions s ON u.id = s.user_id
 		WHERE s.id = ? AND s.expires_at > ?
 	`, sessionID, time.Now()).Scan(
 		&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName,
-		&nickname, &user.DateOfBirth, &aboutMe, &avatarURL,
+		&nickname, &user.DateOfBirth, &aboutMe, &avatarURL, &user.EmailVerified, &user.TwoFactor,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -0,0 +1,258 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/db/sqlite/sqlitetest"
+	"database/sql"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func createTestSession(t *testing.T, userID int, userAgent string, rememberMe bool) string {
+	t.Helper()
+	sessionID, _, err := CreateSession(userID, userAgent, "127.0.0.1", rememberMe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessionID
+}
+
+func TestUserSessions(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+
+	laptop := createTestSession(t, alice, "laptop", false)
+	phone := createTestSession(t, alice, "phone", false)
+	tablet := createTestSession(t, alice, "tablet", true)
+	bobs := createTestSession(t, bob, "bob's laptop", false)
+
+	// Logging in on a new device keeps the others
+	sessions, err := GetUserSessions(alice, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicIDs := make(map[string]string) // Public ID of each user agent
+	var current []string
+	for _, session := range sessions {
+		publicIDs[session.UserAgent] = session.ID
+		if session.Current {
+			current = append(current, session.UserAgent)
+		}
+		if session.ID == laptop || session.ID == phone || session.ID == tablet {
+			t.Errorf("session of %s lists its session ID", session.UserAgent)
+		}
+	}
+	if len(sessions) != 3 || len(publicIDs) != 3 {
+		t.Fatalf("alice has %d sessions with %d public IDs, want 3", len(sessions), len(publicIDs))
+	}
+	if !slices.Equal(current, []string{"phone"}) {
+		t.Errorf("current sessions are %v, want the phone", current)
+	}
+	bobsSessions, err := GetUserSessions(bob, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bobsSessions) != 1 {
+		t.Fatalf("bob has %d sessions, want 1", len(bobsSessions))
+	}
+
+	revocations := []struct {
+		name     string
+		userID   int
+		publicID string
+		want     string // Session ID revoked, empty when none
+	}{
+		{name: "another user's session", userID: alice, publicID: bobsSessions[0].ID},
+		{name: "unknown session", userID: alice, publicID: "unknown"},
+		{name: "own session", userID: alice, publicID: publicIDs["laptop"], want: laptop},
+		{name: "already revoked", userID: alice, publicID: publicIDs["laptop"]},
+	}
+	for _, revocation := range revocations {
+		sessionID, err := RevokeSession(revocation.userID, revocation.publicID)
+		if revocation.want == "" {
+			if err != sql.ErrNoRows {
+				t.Errorf("%s: got session %q and error %v, want %v", revocation.name, sessionID, err, sql.ErrNoRows)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", revocation.name, err)
+		}
+		if sessionID != revocation.want {
+			t.Errorf("%s: revoked %q, want %q", revocation.name, sessionID, revocation.want)
+		}
+	}
+
+	// Logging out everywhere else keeps the current session and other users' ones
+	revoked, err := RevokeOtherSessions(alice, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(revoked, []string{tablet}) {
+		t.Errorf("revoked %v, want the tablet session", revoked)
+	}
+
+	valid := []struct {
+		sessionID string
+		want      bool
+	}{
+		{laptop, false},
+		{phone, true},
+		{tablet, false},
+		{bobs, true},
+	}
+	for _, session := range valid {
+		got, err := IsSessionValid(session.sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != session.want {
+			t.Errorf("session %s valid = %v, want %v", session.sessionID, got, session.want)
+		}
+	}
+}
+
+// ageSession moves every timestamp of the session back by d, as if d had passed
+func ageSession(t *testing.T, sessionID string, d time.Duration) {
+	t.Helper()
+	var createdAt, expiresAt, absoluteExpiresAt time.Time
+	var lastUsedAt sql.NullTime
+	err := sqlite.DB.QueryRow("SELECT created_at, last_used_at, expires_at, absolute_expires_at FROM sessions WHERE id = ?", sessionID).
+		Scan(&createdAt, &lastUsedAt, &expiresAt, &absoluteExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlite.DB.Exec("UPDATE sessions SET created_at = ?, last_used_at = ?, expires_at = ?, absolute_expires_at = ? WHERE id = ?",
+		createdAt.Add(-d), lastUsedAt.Time.Add(-d), expiresAt.Add(-d), absoluteExpiresAt.Add(-d), sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	sqlitetest.Open(t)
+	userID := sqlitetest.CreateUser(t, "alice")
+
+	type step struct {
+		wait    time.Duration // Time passing before the step
+		refresh bool          // The session is used after the wait
+		valid   bool          // Whether it is valid afterwards
+	}
+	// Used every 20 hours, which is within the idle timeout, up to 160 hours after login
+	var daily []step
+	for i := 0; i < 8; i++ {
+		daily = append(daily, step{wait: 20 * time.Hour, refresh: true, valid: true})
+	}
+
+	tests := []struct {
+		name       string
+		rememberMe bool
+		steps      []step
+	}{
+		{name: "fresh", steps: []step{{valid: true}}},
+		{name: "idle past the timeout", steps: []step{
+			{wait: 23 * time.Hour, valid: true},
+			{wait: 2 * time.Hour, valid: false},
+		}},
+		{name: "use slides the expiry", steps: []step{
+			{wait: 20 * time.Hour, refresh: true, valid: true},
+			{wait: 20 * time.Hour, valid: true},
+			{wait: 5 * time.Hour, valid: false},
+		}},
+		{name: "use cannot revive an expired session", steps: []step{
+			{wait: 25 * time.Hour, refresh: true, valid: false},
+		}},
+		{name: "used until the absolute timeout", steps: append(daily,
+			step{wait: 7 * time.Hour, valid: true},
+			step{wait: time.Hour, refresh: true, valid: false},
+		)},
+		{name: "remember me lasts unused", rememberMe: true, steps: []step{
+			{wait: 29 * 24 * time.Hour, valid: true},
+			{wait: 2 * 24 * time.Hour, valid: false},
+		}},
+		{name: "remember me does not slide", rememberMe: true, steps: []step{
+			{wait: 29 * 24 * time.Hour, refresh: true, valid: true},
+			{wait: 2 * 24 * time.Hour, valid: false},
+		}},
+	}
+	stillValid := 0
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sessionID := createTestSession(t, userID, "browser", test.rememberMe)
+			for i, step := range test.steps {
+				ageSession(t, sessionID, step.wait)
+				if step.refresh {
+					if err := RefreshSession(sessionID, "127.0.0.1"); err != nil {
+						t.Fatal(err)
+					}
+				}
+				valid, err := IsSessionValid(sessionID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if valid != step.valid {
+					t.Fatalf("step %d: valid = %v, want %v", i, valid, step.valid)
+				}
+			}
+			if test.steps[len(test.steps)-1].valid {
+				stillValid++
+			}
+		})
+	}
+
+	// The sweep removes exactly the expired sessions
+	if err := DeleteExpiredSessions(); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := sqlite.DB.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != stillValid {
+		t.Errorf("%d sessions left after the sweep, want %d", count, stillValid)
+	}
+}
+
+func TestRefreshSessionWritesOnlyWhenStale(t *testing.T) {
+	sqlitetest.Open(t)
+	sessionID := createTestSession(t, sqlitetest.CreateUser(t, "alice"), "browser", false)
+
+	lastUse := func() (time.Time, string) {
+		t.Helper()
+		var lastUsedAt time.Time
+		var ipAddress string
+		if err := sqlite.DB.QueryRow("SELECT last_used_at, ip_address FROM sessions WHERE id = ?", sessionID).Scan(&lastUsedAt, &ipAddress); err != nil {
+			t.Fatal(err)
+		}
+		return lastUsedAt, ipAddress
+	}
+
+	tests := []struct {
+		name      string
+		wait      time.Duration
+		ipAddress string
+		written   bool
+	}{
+		{name: "right after login", ipAddress: "127.0.0.1", written: false},
+		{name: "from another address", ipAddress: "10.0.0.1", written: true},
+		{name: "again from there", ipAddress: "10.0.0.1", written: false},
+		{name: "once stale", wait: sessionRefreshInterval() + time.Second, ipAddress: "10.0.0.1", written: true},
+	}
+	for _, test := range tests {
+		ageSession(t, sessionID, test.wait)
+		before, _ := lastUse()
+		if err := RefreshSession(sessionID, test.ipAddress); err != nil {
+			t.Fatal(err)
+		}
+		after, ipAddress := lastUse()
+		if written := !after.Equal(before); written != test.written {
+			t.Errorf("%s: written = %v, want %v", test.name, written, test.written)
+		}
+		if ipAddress != test.ipAddress {
+			t.Errorf("%s: session address is %s, want %s", test.name, ipAddress, test.ipAddress)
+		}
+	}
+}
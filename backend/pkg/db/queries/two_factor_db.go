@@ -0,0 +1,285 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// How long the second step of a login can be completed after the password step
+	LoginChallengeTTL = 5 * time.Minute
+	// Wrong codes allowed for one login before the password has to be entered again
+	MaxLoginChallengeAttempts = 5
+
+	recoveryCodeCount = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// normalizeRecoveryCode lets users type recovery codes without the dash or in capitals
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// GetTOTPSecret returns the TOTP secret of the user, empty when 2FA was never set up,
+// and whether 2FA is enabled with it
+func GetTOTPSecret(userID int) (string, bool, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := sqlite.DB.QueryRow(
+		"SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = ?", userID,
+	).Scan(&secret, &enabled)
+	if err != nil {
+		log.Printf("Error retrieving TOTP secret: %v", err)
+		return "", false, err
+	}
+	return secret.String, enabled, nil
+}
+
+// SetPendingTOTPSecret stores a new secret for a user setting up 2FA. It only takes
+// effect once EnableTOTP confirms it, and does nothing while 2FA is enabled.
+func SetPendingTOTPSecret(userID int, secret string) error {
+	_, err := sqlite.DB.Exec(
+		"UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ? AND totp_enabled_at IS NULL",
+		secret, userID,
+	)
+	if err != nil {
+		log.Printf("Error storing TOTP secret: %v", err)
+		return err
+	}
+	return nil
+}
+
+// EnableTOTP turns on 2FA with the pending secret, step being the time step of the
+// code that confirmed it. It returns the user's recovery codes, which are only
+// stored hashed and cannot be shown again.
+func EnableTOTP(userID int, step int64) ([]string, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE users SET totp_enabled_at = ?, totp_last_step = ?
+		WHERE id = ? AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL
+	`, time.Now(), step, userID)
+	if err != nil {
+		log.Printf("Error enabling TOTP: %v", err)
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns off 2FA, forgetting the secret and the recovery codes
+func DisableTOTP(userID int) error {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?", userID,
+	)
+	if err != nil {
+		log.Printf("Error disabling TOTP: %v", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		log.Printf("Error deleting recovery codes: %v", err)
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM login_challenges WHERE user_id = ?", userID); err != nil {
+		log.Printf("Error deleting login challenges: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UseTOTPStep records that the user's code of the time step was accepted. It returns
+// false when a code of that step or a later one was already used, so the same code
+// cannot log in twice.
+func UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := sqlite.DB.Exec(`
+		UPDATE users SET totp_last_step = ?1
+		WHERE id = ?2 AND totp_enabled_at IS NOT NULL AND (totp_last_step IS NULL OR totp_last_step < ?1)
+	`, step, userID)
+	if err != nil {
+		log.Printf("Error recording TOTP step: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Error recording TOTP step: %v", err)
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with 2FA enabled,
+// the previous ones stop working
+func RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, err
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the user and generates new ones,
+// formatted as two groups of five characters
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		log.Printf("Error deleting recovery codes: %v", err)
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		secret := make([]byte, 10)
+		if _, err := rand.Read(secret); err != nil {
+			log.Printf("Error generating recovery code: %v", err)
+			return nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(secret))[:10]
+		if _, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashToken(code),
+		); err != nil {
+			log.Printf("Error storing recovery code: %v", err)
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes. It returns false when the
+// code is not one of them or was already used.
+func UseRecoveryCode(userID int, code string) (bool, error) {
+	result, err := sqlite.DB.Exec(`
+		UPDATE recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, time.Now(), userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		log.Printf("Error using recovery code: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Error using recovery code: %v", err)
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// CountRecoveryCodes returns how many recovery codes the user has left
+func CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := sqlite.DB.QueryRow(
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID,
+	).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting recovery codes: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// CreateLoginChallenge starts the second step of a login for a user with 2FA, and
+// returns the token the client sends back with the code
+func CreateLoginChallenge(userID int, rememberMe bool) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating login challenge: %v", err)
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	_, err := sqlite.DB.Exec(`
+		INSERT INTO login_challenges (user_id, token_hash, remember_me, expires_at) VALUES (?, ?, ?, ?)
+	`, userID, hashToken(token), rememberMe, time.Now().Add(LoginChallengeTTL))
+	if err != nil {
+		log.Printf("Error creating login challenge: %v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// AttemptLoginChallenge counts an attempt at the second step of a login and returns
+// the user and whether they asked to be remembered. It returns sql.ErrNoRows once the
+// token is unknown, expired or out of attempts.
+func AttemptLoginChallenge(token string) (int, bool, error) {
+	var userID int
+	var rememberMe bool
+	err := sqlite.DB.QueryRow(`
+		UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token_hash = ? AND expires_at > ? AND attempts < ?
+		RETURNING user_id, remember_me
+	`, hashToken(token), time.Now(), MaxLoginChallengeAttempts).Scan(&userID, &rememberMe)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error checking login challenge: %v", err)
+		}
+		return 0, false, err
+	}
+	return userID, rememberMe, nil
+}
+
+// DeleteLoginChallenge ends a login challenge once it is completed
+func DeleteLoginChallenge(token string) error {
+	_, err := sqlite.DB.Exec("DELETE FROM login_challenges WHERE token_hash = ?", hashToken(token))
+	if err != nil {
+		log.Printf("Error deleting login challenge: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredLoginChallenges removes the login challenges that can no longer be completed
+func DeleteExpiredLoginChallenges() error {
+	_, err := sqlite.DB.Exec(
+		"DELETE FROM login_challenges WHERE expires_at <= ? OR attempts >= ?", time.Now(), MaxLoginChallengeAttempts,
+	)
+	if err != nil {
+		log.Printf("Error deleting expired login challenges: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite/sqlitetest"
+	"backend/pkg/utilities"
+	"testing"
+	"time"
+)
+
+func TestUseTOTPStepRefusesReplay(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+	carol := sqlitetest.CreateUser(t, "carol")
+
+	// Codes of steps before the one confirming the setup are already spent
+	secret, err := utilities.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPendingTOTPSecret(alice, secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnableTOTP(alice, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		userID int
+		step   int64
+		want   bool
+	}{
+		{name: "step of the setup", userID: alice, step: 100, want: false},
+		{name: "next step", userID: alice, step: 101, want: true},
+		{name: "same code again", userID: alice, step: 101, want: false},
+		{name: "earlier code within the skew", userID: alice, step: 100, want: false},
+		{name: "step skipped ahead", userID: alice, step: 105, want: true},
+		{name: "skipped step", userID: alice, step: 103, want: false},
+		{name: "without 2FA", userID: carol, step: 200, want: false},
+	}
+	for _, step := range steps {
+		got, err := UseTOTPStep(step.userID, step.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("%s: accepted = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// The same code entered twice within its window maps to the same step. The
+	// secret is the one of the RFC 6238 test vectors, 287082 its code of step 1.
+	if err := SetPendingTOTPSecret(bob, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnableTOTP(bob, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		step, ok := utilities.ValidateTOTP("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", "287082", time.Unix(59+int64(i)*20, 0))
+		if !ok {
+			t.Fatal("valid code refused")
+		}
+		got, err := UseTOTPStep(bob, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("use %d of the code: accepted = %v, want %v", i, got, want)
+		}
+	}
+}
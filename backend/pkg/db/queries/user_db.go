@@ -9,7 +9,7 @@ import (
 
 func GetUserByEmailOrUsername(emailOrUsername string) (models.User, error) {
 	var user models.User
-	err := sqlite.DB.QueryRow("SELECT id, username, email, first_name, last_name, nickname, date_of_birth, about_me, is_public, avatar_url, password FROM users WHERE email = $1 OR username = $1", emailOrUsername).Scan(&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Nickname, &user.DateOfBirth, &user.AboutMe, &user.IsPublic, &user.AvatarURL, &user.Password)
+	err := sqlite.DB.QueryRow("SELECT id, username, email, first_name, last_name, nickname, date_of_birth, about_me, is_public, avatar_url, password, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL FROM users WHERE email = $1 OR username = $1", emailOrUsername).Scan(&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Nickname, &user.DateOfBirth, &user.AboutMe, &user.IsPublic, &user.AvatarURL, &user.Password, &user.EmailVerified, &user.TwoFactor)
 	if err != nil {
 		return models.User{}, err
 	}
@@ -51,8 +51,8 @@ func GetUserItemByID(userID int) (models.UserItem, error) {
 
 func GetUserByUsername(username string) (models.User, error) {
 	var user models.User
-	row := sqlite.DB.QueryRow("SELECT id, username, email, first_name, last_name, nickname, date_of_birth, about_me, is_public, avatar_url FROM users WHERE username = ?", username)
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Nickname, &user.DateOfBirth, &user.AboutMe, &user.IsPublic, &user.AvatarURL)
+	row := sqlite.DB.QueryRow("SELECT id, username, email, first_name, last_name, nickname, date_of_birth, about_me, is_public, avatar_url, message_requests FROM users WHERE username = ?", username)
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Nickname, &user.DateOfBirth, &user.AboutMe, &user.IsPublic, &user.AvatarURL, &user.MessageRequests)
 	if err != nil {
 		log.Printf("Error getting user by username: %v", err)
 		return user, err
@@ -75,10 +75,11 @@ func UpdateUser(user models.User) error {
 	_, err := sqlite.DB.Exec(`
 		UPDATE users 
 		SET first_name = ?, last_name = ?, nickname = ?, date_of_birth = ?, 
-			about_me = ?, avatar_url = COALESCE(NULLIF(?, ''), 'ProfileImage.png'), is_public = ? 
+			about_me = ?, avatar_url = COALESCE(NULLIF(?, ''), 'ProfileImage.png'), is_public = ?,
+			message_requests = COALESCE(NULLIF(?, ''), message_requests)
 		WHERE id = ?`,
 		user.FirstName, user.LastName, user.Nickname, user.DateOfBirth,
-		user.AboutMe, user.AvatarURL, user.IsPublic, user.ID)
+		user.AboutMe, user.AvatarURL, user.IsPublic, user.MessageRequests, user.ID)
 	if err != nil {
 		log.Printf("Error updating user: %v", err)
 		return err
@@ -0,0 +1,78 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"backend/pkg/models"
+	"log"
+)
+
+// UserEventRetention is the number of most recent events kept per user for replay,
+// older ones are deleted by PruneUserEvents
+const UserEventRetention = 500
+
+// CreateUserEvent stores an outbound real-time event for a user and returns its
+// sequence number, one higher than the user's previous event.
+func CreateUserEvent(userID int, eventType string, payload string) (int64, error) {
+	var seq int64
+	err := sqlite.DB.QueryRow(`
+		INSERT INTO user_events (user_id, seq, type, payload)
+		SELECT ?, COALESCE(MAX(seq), 0) + 1, ?, ? FROM user_events WHERE user_id = ?
+		RETURNING seq
+	`, userID, eventType, payload, userID).Scan(&seq)
+	if err != nil {
+		log.Printf("Error creating user event: %v", err)
+		return 0, err
+	}
+	return seq, nil
+}
+
+// PruneUserEvents deletes the events of every user but their UserEventRetention most
+// recent ones. Clients whose gap reaches past what is left have to resync.
+func PruneUserEvents() error {
+	_, err := sqlite.DB.Exec(`
+		DELETE FROM user_events
+		WHERE seq <= (SELECT MAX(latest.seq) FROM user_events latest WHERE latest.user_id = user_events.user_id) - ?
+	`, UserEventRetention)
+	if err != nil {
+		log.Printf("Error pruning user events: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetUserEventsAfter returns up to limit events of a user with a sequence number above afterSeq, oldest first.
+func GetUserEventsAfter(userID int, afterSeq int64, limit int) ([]models.UserEvent, error) {
+	rows, err := sqlite.DB.Query(`
+		SELECT seq, type, payload FROM user_events
+		WHERE user_id = ? AND seq > ?
+		ORDER BY seq ASC
+		LIMIT ?
+	`, userID, afterSeq, limit)
+	if err != nil {
+		log.Printf("Error retrieving user events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.UserEvent
+	for rows.Next() {
+		var event models.UserEvent
+		if err := rows.Scan(&event.Seq, &event.Type, &event.Payload); err != nil {
+			log.Printf("Error scanning user event row: %v", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// GetUserEventSeqRange returns the oldest and newest stored sequence numbers of a user, 0 when there are none.
+func GetUserEventSeqRange(userID int) (int64, int64, error) {
+	var minSeq, maxSeq int64
+	err := sqlite.DB.QueryRow("SELECT COALESCE(MIN(seq), 0), COALESCE(MAX(seq), 0) FROM user_events WHERE user_id = ?", userID).Scan(&minSeq, &maxSeq)
+	if err != nil {
+		log.Printf("Error retrieving user event range: %v", err)
+		return 0, 0, err
+	}
+	return minSeq, maxSeq, nil
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite/sqlitetest"
+	"testing"
+)
+
+func TestCreateUserEventSequencesPerUser(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+
+	steps := []struct {
+		userID int
+		want   int64
+	}{
+		{alice, 1}, {alice, 2}, {bob, 1}, {alice, 3}, {bob, 2},
+	}
+	for i, step := range steps {
+		seq, err := CreateUserEvent(step.userID, "notification", "{}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != step.want {
+			t.Errorf("event %d of user %d got seq %d, want %d", i, step.userID, seq, step.want)
+		}
+	}
+
+	events, err := GetUserEventsAfter(alice, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Seq != 2 || events[1].Seq != 3 {
+		t.Errorf("events of alice after 1 = %+v, want seq 2 and 3", events)
+	}
+}
+
+func TestPruneUserEvents(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+
+	for i := 0; i < UserEventRetention+20; i++ {
+		if _, err := CreateUserEvent(alice, "notification", "{}"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := CreateUserEvent(bob, "notification", "{}"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := PruneUserEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		userID           int
+		wantMin, wantMax int64
+	}{
+		{alice, 21, UserEventRetention + 20},
+		{bob, 1, 3},
+	}
+	for _, test := range tests {
+		minSeq, maxSeq, err := GetUserEventSeqRange(test.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if minSeq != test.wantMin || maxSeq != test.wantMax {
+			t.Errorf("user %d keeps seq %d to %d, want %d to %d", test.userID, minSeq, maxSeq, test.wantMin, test.wantMax)
+		}
+	}
+
+	// Numbering goes on from the newest event, not from what is left
+	seq, err := CreateUserEvent(alice, "notification", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != UserEventRetention+21 {
+		t.Errorf("next event got seq %d, want %d", seq, UserEventRetention+21)
+	}
+}
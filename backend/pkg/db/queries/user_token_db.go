@@ -0,0 +1,113 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// Purposes of the single-use tokens emailed to users, and how long they stay valid
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+
+	PasswordResetTokenTTL     = time.Hour
+	EmailVerificationTokenTTL = 48 * time.Hour
+)
+
+// hashToken returns the hash stored in place of a token, so that a leaked database
+// cannot be used to reset passwords
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateUserToken generates a token for the user, valid for ttl, and returns it to be
+// emailed. Earlier unused tokens of the user for the same purpose stop working.
+func CreateUserToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating token: %v", err)
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose); err != nil {
+		log.Printf("Error deleting previous tokens: %v", err)
+		return "", err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES (?, ?, ?, ?)
+	`, userID, purpose, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		log.Printf("Error creating token: %v", err)
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeUserToken marks the token as used and returns its user. It returns
+// sql.ErrNoRows when the token is unknown, expired, already used or for another purpose.
+func ConsumeUserToken(token string, purpose string) (int, error) {
+	now := time.Now()
+	var userID int
+	err := sqlite.DB.QueryRow(`
+		UPDATE user_tokens SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+	`, now, hashToken(token), purpose, now).Scan(&userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error consuming token: %v", err)
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// UpdateUserPassword replaces the password hash of the user
+func UpdateUserPassword(userID int, passwordHash string) error {
+	_, err := sqlite.DB.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, userID)
+	if err != nil {
+		log.Printf("Error updating password: %v", err)
+		return err
+	}
+	return nil
+}
+
+// MarkEmailVerified records that the user confirmed their email address
+func MarkEmailVerified(userID int) error {
+	_, err := sqlite.DB.Exec("UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ?", time.Now(), userID)
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		return err
+	}
+	return nil
+}
+
+// IsEmailVerified checks whether the user confirmed their email address
+func IsEmailVerified(userID int) (bool, error) {
+	var verified bool
+	err := sqlite.DB.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&verified)
+	if err != nil {
+		log.Printf("Error checking email verification: %v", err)
+		return false, err
+	}
+	return verified, nil
+}
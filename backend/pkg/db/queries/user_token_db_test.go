@@ -0,0 +1,92 @@
+package query
+
+import (
+	"backend/pkg/db/sqlite/sqlitetest"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestConsumeUserToken(t *testing.T) {
+	sqlitetest.Open(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+
+	createToken := func(userID int, purpose string, ttl time.Duration) string {
+		t.Helper()
+		token, err := CreateUserToken(userID, purpose, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	type use struct {
+		purpose string
+		want    int // User the token belongs to, 0 when it must be refused
+	}
+	tests := []struct {
+		name  string
+		token func() string
+		uses  []use
+	}{
+		{
+			name:  "single use",
+			token: func() string { return createToken(alice, TokenPasswordReset, PasswordResetTokenTTL) },
+			uses:  []use{{TokenPasswordReset, alice}, {TokenPasswordReset, 0}},
+		},
+		{
+			name:  "expired",
+			token: func() string { return createToken(alice, TokenPasswordReset, -time.Second) },
+			uses:  []use{{TokenPasswordReset, 0}},
+		},
+		{
+			name:  "other purpose",
+			token: func() string { return createToken(bob, TokenEmailVerification, EmailVerificationTokenTTL) },
+			uses:  []use{{TokenPasswordReset, 0}, {TokenEmailVerification, bob}},
+		},
+		{
+			name: "replaced by a newer one",
+			token: func() string {
+				older := createToken(bob, TokenPasswordReset, PasswordResetTokenTTL)
+				createToken(bob, TokenPasswordReset, PasswordResetTokenTTL)
+				return older
+			},
+			uses: []use{{TokenPasswordReset, 0}},
+		},
+		{
+			name: "newer one of another purpose",
+			token: func() string {
+				token := createToken(bob, TokenPasswordReset, PasswordResetTokenTTL)
+				createToken(bob, TokenEmailVerification, EmailVerificationTokenTTL)
+				return token
+			},
+			uses: []use{{TokenPasswordReset, bob}},
+		},
+		{
+			name:  "unknown",
+			token: func() string { return "not-a-token" },
+			uses:  []use{{TokenPasswordReset, 0}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := test.token()
+			for i, use := range test.uses {
+				userID, err := ConsumeUserToken(token, use.purpose)
+				if use.want == 0 {
+					if err != sql.ErrNoRows {
+						t.Errorf("use %d as %s: got user %d and error %v, want %v", i, use.purpose, userID, err, sql.ErrNoRows)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("use %d as %s: %v", i, use.purpose, err)
+				}
+				if userID != use.want {
+					t.Errorf("use %d as %s: got user %d, want %d", i, use.purpose, userID, use.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !sqlite_fts5
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SearchIndexed tells whether messages are searched through the FTS5 index
+const SearchIndexed = false
+
+// SetUpMessageSearch drops the triggers of the full-text index, left by a server
+// built with FTS5, as writing to messages would fail on them. Messages are searched
+// without the index.
+func SetUpMessageSearch(db *sql.DB) error {
+	_, err := db.Exec(`
+		DROP TRIGGER IF EXISTS messages_fts_insert;
+		DROP TRIGGER IF EXISTS messages_fts_delete;
+		DROP TRIGGER IF EXISTS messages_fts_update;
+	`)
+	if err != nil {
+		return fmt.Errorf("could not drop the message search triggers: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build sqlite_fts5
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SearchIndexed tells whether messages are searched through the FTS5 index
+const SearchIndexed = true
+
+// SetUpMessageSearch creates the full-text index of the messages and the triggers
+// keeping it in sync. The index is rebuilt when the triggers are missing, as they are
+// after a server built without FTS5 ran on the database.
+func SetUpMessageSearch(db *sql.DB) error {
+	var triggers int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%'").Scan(&triggers); err != nil {
+		return fmt.Errorf("could not check the message search index: %v", err)
+	}
+	if triggers == 3 {
+		return nil
+	}
+
+	_, err := db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+			content,
+			content = 'messages',
+			content_rowid = 'id',
+			tokenize = 'unicode61 remove_diacritics 2'
+		);
+
+		INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
+
+		-- Keep the index in sync with messages, edits and deletes only change the content
+		CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+			INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+		END;
+	`)
+	if err != nil {
+		return fmt.Errorf("could not create the message search index: %v", err)
+	}
+	return nil
+}
@@ -46,6 +46,9 @@ func ApplyMigrations(db *sql.DB) error {
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
 		return fmt.Errorf("could not apply migrations: %v", err)
 	}
+	if err := SetUpMessageSearch(db); err != nil {
+		return err
+	}
 
 	// if the database is empty, insert fake data
 	if err := insertFakeData(db); err != nil {
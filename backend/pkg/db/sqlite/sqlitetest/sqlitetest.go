@@ -0,0 +1,70 @@
+// Package sqlitetest gives tests a database of their own with every migration applied.
+package sqlitetest
+
+import (
+	"backend/pkg/db/sqlite"
+	"database/sql"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Open creates an empty database in a temporary directory, applies the migrations
+// and makes it the one the queries use until the test ends.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		t.Fatalf("creating migration driver: %v", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrationsDir()), "sqlite3", driver)
+	if err != nil {
+		t.Fatalf("creating migration instance: %v", err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("applying migrations: %v", err)
+	}
+	if err := sqlite.SetUpMessageSearch(db); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := sqlite.DB
+	sqlite.DB = db
+	t.Cleanup(func() { sqlite.DB = previous })
+	return db
+}
+
+// CreateUser inserts a user with a verified email and the default profile, as
+// registration would, and returns their ID.
+func CreateUser(t testing.TB, username string) int {
+	t.Helper()
+
+	var id int
+	err := sqlite.DB.QueryRow(`
+		INSERT INTO users (username, email, password, first_name, last_name, nickname, date_of_birth, about_me, avatar_url, email_verified_at)
+		VALUES (?1, ?1 || '@example.com', 'x', ?1, 'Test', '', '2000-01-01', '', 'ProfileImage.png', CURRENT_TIMESTAMP)
+		RETURNING id
+	`, username).Scan(&id)
+	if err != nil {
+		t.Fatalf("creating user %s: %v", username, err)
+	}
+	return id
+}
+
+// migrationsDir finds the migrations next to this package, wherever the test runs from
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "migrations")
+}
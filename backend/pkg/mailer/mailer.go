@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text emails to users
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// New returns an SMTPMailer when SMTP_ADDR is set, configured by SMTP_FROM,
+// SMTP_USERNAME and SMTP_PASSWORD. Otherwise emails are written to the MAIL_DIR
+// directory, or only logged when it is not set either.
+func New() Mailer {
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "no-reply@localhost"
+		}
+		log.Printf("Sending emails through SMTP server %s", addr)
+		return &SMTPMailer{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+	return &FileMailer{Dir: os.Getenv("MAIL_DIR")}
+}
+
+// SMTPMailer sends emails through an SMTP server, authenticating when Username is set
+type SMTPMailer struct {
+	Addr     string // host:port of the server
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, buildMessage(m.From, to, subject, body))
+}
+
+// FileMailer stands in for a mail server during development and tests. Every email
+// is written to its own file in Dir and logged, or only logged when Dir is empty.
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(to string, subject string, body string) error {
+	message := buildMessage("no-reply@localhost", to, subject, body)
+	if m.Dir == "" {
+		log.Printf("Email not sent, no mailer configured:\n%s", message)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	path := filepath.Join(m.Dir, fmt.Sprintf("%d.eml", time.Now().UnixNano()))
+	if err := os.WriteFile(path, message, 0o600); err != nil {
+		return err
+	}
+	log.Printf("Email to %s written to %s", to, path)
+	return nil
+}
+
+// buildMessage formats an email with its headers. Line breaks are removed from the
+// header values so they cannot add headers of their own.
+func buildMessage(from string, to string, subject string, body string) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	return []byte("From: " + header.Replace(from) + "\r\n" +
+		"To: " + header.Replace(to) + "\r\n" +
+		"Subject: " + header.Replace(subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n"))
+}
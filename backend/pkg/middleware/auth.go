@@ -3,11 +3,14 @@ package middleware
 import (
 	query "backend/pkg/db/queries"
 	"backend/pkg/models"
+	"backend/pkg/utilities"
 	"context"
 	"errors"
 	"net/http"
 )
 
+// AuthMiddleware refuses requests without a valid session, refreshes the session and
+// puts its user in the request context
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := GetAuthenticatedUser(r)
@@ -22,6 +25,48 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// RequireVerifiedEmail refuses requests that create content or contact other users
+// until the user has confirmed their email address. Reading is still allowed, and
+// requests without a session are left to the handler to refuse.
+func RequireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := GetAuthenticatedUser(r)
+		if err == nil && !user.EmailVerified {
+			http.Error(w, "Verify your email address first", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// RequireAdmin refuses requests from users who are not site administrators. It goes
+// inside AuthMiddleware, which puts the user in the context.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := GetUserFromContext(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		admin, err := query.IsAdmin(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+		if !admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 // GetAuthenticatedUser retrieves the authenticated user from the session
 func GetAuthenticatedUser(r *http.Request) (*models.User, error) {
 	cookie, err := r.Cookie("session_id")
@@ -38,6 +83,10 @@ func GetAuthenticatedUser(r *http.Request) (*models.User, error) {
 		return nil, http.ErrNoCookie
 	}
 
+	// Every authenticated request slides the session's idle expiry, failing to do so
+	// is not a reason to refuse the request
+	query.RefreshSession(cookie.Value, utilities.ClientIP(r))
+
 	return user, nil
 }
 
@@ -1,26 +1,47 @@
 package middleware
 
 import (
+	"backend/pkg/mailer"
 	"backend/pkg/websocket"
 	"database/sql"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
 type AppCore struct {
-	Hub *websocket.Hub
+	Hub    *websocket.Hub
+	Mailer mailer.Mailer
+	AppURL string // Where the frontend is served, for the links sent by email
 }
 
 func NewAppCore(db *sql.DB) *AppCore { // Accept DB as a parameter
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:3000"
+	}
+
+	// Replicas share real-time deliveries through Redis when REDIS_ADDR is set
+	var hub *websocket.Hub
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		log.Printf("Using Redis broker at %s", addr)
+		hub = websocket.NewHubWithBroker(websocket.NewRedisBroker(addr, "social-network:hub"))
+	} else {
+		hub = websocket.NewHub()
+	}
 	return &AppCore{
-		Hub: websocket.NewHub(),
+		Hub:    hub,
+		Mailer: mailer.New(),
+		AppURL: strings.TrimSuffix(appURL, "/"),
 	}
 }
 
 func (c *AppCore) Close() {
 //	c.DB.Close()
 	// Add any other cleanup logic here
+	c.Hub.Close()
 }
 
 func CorsMiddleware(next http.Handler) http.Handler {
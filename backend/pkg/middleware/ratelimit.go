@@ -1,6 +1,7 @@
 package middleware
 
 import (
+    "backend/pkg/utilities"
     "net/http"
     "sync"
     "time"
@@ -19,21 +20,31 @@ func NewRateLimiter() *RateLimiter {
 
 func (rl *RateLimiter) RateLimitMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        ip := r.RemoteAddr
-
-        rl.mu.Lock()
-        defer rl.mu.Unlock()
-
-        now := time.Now()
-        if len(rl.requests[ip]) >= 100 {
-            if now.Sub(rl.requests[ip][0]) < time.Minute {
-                http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-                return
-            }
-            rl.requests[ip] = rl.requests[ip][1:]
+        // Without the port, which changes with every connection of the same client
+        ip := utilities.ClientIP(r)
+
+        if !rl.allow(ip) {
+            http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+            return
         }
-        rl.requests[ip] = append(rl.requests[ip], now)
 
+        // The lock is released before serving, long-lived streams must not block other requests
         next.ServeHTTP(w, r)
     })
 }
+
+// allow records a request from ip and reports whether it is within the limit
+func (rl *RateLimiter) allow(ip string) bool {
+    rl.mu.Lock()
+    defer rl.mu.Unlock()
+
+    now := time.Now()
+    if len(rl.requests[ip]) >= 100 {
+        if now.Sub(rl.requests[ip][0]) < time.Minute {
+            return false
+        }
+        rl.requests[ip] = rl.requests[ip][1:]
+    }
+    rl.requests[ip] = append(rl.requests[ip], now)
+    return true
+}
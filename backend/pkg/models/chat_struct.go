@@ -5,19 +5,123 @@ import (
 )
 
 type ChatMessage struct {
-	ID         int       `json:"id"`
-	SenderID   int       `json:"senderId"`
-	ReceiverID int       `json:"receiverId"`
-	GroupID    int       `json:"groupId"`
-	Content    string    `json:"content"`
-	CreatedAt  time.Time `json:"createdAt"`
+	ID          int              `json:"id"`
+	SenderID    int              `json:"senderId"`
+	ReceiverID  int              `json:"receiverId"`
+	GroupID     int              `json:"groupId"`
+	Content     string           `json:"content"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	ReadBy      []int            `json:"readBy,omitempty"`    // IDs of the recipients who have read the message
+	EditedAt    *time.Time       `json:"editedAt,omitempty"`  // Set once the content has been edited
+	DeletedAt   *time.Time       `json:"deletedAt,omitempty"` // Set on a deleted message, whose content is cleared
+	Attachments []ChatAttachment `json:"attachments,omitempty"`
+	ReplyToID   int              `json:"replyToId,omitempty"` // Earlier message of the same conversation this one quotes
+	ReplyTo     *ChatMessage     `json:"replyTo,omitempty"`   // The quoted message, without its own reply, reactions or attachments
+	Reactions   []ChatReaction   `json:"reactions,omitempty"`
+}
+
+// ChatReaction is the reaction a participant left on a chat message, one per participant.
+// Reaction types are the same as for posts and comments.
+type ChatReaction struct {
+	UserID         int    `json:"userId"`
+	ReactionTypeID int    `json:"reactionTypeId"`
+	Name           string `json:"name"`
+	IconURL        string `json:"iconUrl"`
+}
+
+// ChatAttachment is a file sent with a chat message. It is only downloadable by the
+// conversation's participants through URL, never through the public images endpoint.
+type ChatAttachment struct {
+	ID          int    `json:"id"`
+	MessageID   int    `json:"messageId"`
+	FileName    string `json:"-"` // Name of the stored file, never shown to clients
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+}
+
+// MessageSearchResult is a message matching a search and the conversation it
+// belongs to, either the other user of a private chat or the group.
+type MessageSearchResult struct {
+	Message   ChatMessage `json:"message"`
+	Highlight string      `json:"highlight"` // HTML-escaped excerpt with the matches wrapped in <mark>
+	UserB     *UserItem   `json:"userB,omitempty"`
+	Group     *Group      `json:"group,omitempty"`
+	Link      string      `json:"link"` // Page of the conversation history ending with the message
+}
+
+// MessageEdit is a previous version of an edited message. EditedAt is when it was replaced.
+type MessageEdit struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"messageId"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"editedAt"`
+}
+
+// ReadReceipt tells the participants of a conversation that ReaderID has read
+// every message in it up to and including MessageID.
+type ReadReceipt struct {
+	ReaderID  int       `json:"readerId"`
+	UserID    int       `json:"userId"` // Private chat partner whose messages were read, 0 in groups
+	GroupID   int       `json:"groupId"`
+	MessageID int       `json:"messageId"`
+	ReadAt    time.Time `json:"readAt"`
 }
 
 type Chat struct {
-	Messages     []ChatMessage `json:"messages"`
-	UserA        UserItem      `json:"userA"`
-	UserB        UserItem      `json:"userB"`
-	Group        Group         `json:"group"`
-	Notification int           `json:"notification"`
-	AllowChat    bool          `json:"allowChat"`
+	Messages     []ChatMessage      `json:"messages"`
+	UserA        UserItem           `json:"userA"`
+	UserB        UserItem           `json:"userB"`
+	Group        Group              `json:"group"`
+	Notification int                `json:"notification"`
+	AllowChat    bool               `json:"allowChat"`
+	HasMore      bool               `json:"hasMore"` // Older messages can be loaded with the first message's ID as cursor
+	Muted        bool               `json:"muted"`
+	Archived     bool               `json:"archived"`
+	Settings     *GroupChatSettings `json:"settings,omitempty"` // Only for group chats
+	Request      string             `json:"request,omitempty"`  // "sent" or "received" while a message request is not accepted
+}
+
+// GroupChatSettings are the rules the group creator and admins set for a group chat.
+// In an announcement-only chat only they can post. With slow mode, other members
+// must wait SlowModeSeconds between two messages.
+type GroupChatSettings struct {
+	GroupID          int           `json:"groupId"`
+	AnnouncementOnly bool          `json:"announcementOnly"`
+	SlowModeSeconds  int           `json:"slowModeSeconds"`
+	Admins           []int         `json:"admins"` // The creator first, then the designated admins
+	PinnedMessages   []ChatMessage `json:"pinnedMessages"`
+}
+
+// Conversation is one entry of the conversation list: the other user of a private
+// chat or the group, a preview of the latest message and the user's unread count.
+type Conversation struct {
+	UserB       *UserItem   `json:"userB,omitempty"`
+	Group       *Group      `json:"group,omitempty"`
+	LastMessage ChatMessage `json:"lastMessage"`
+	Unread      int         `json:"unread"`
+	Muted       bool        `json:"muted"`
+	Archived    bool        `json:"archived"`
+}
+
+// ConversationSettings is how a user set up one of their conversations, the private
+// chat with UserID or the group chat GroupID. A muted conversation raises no unread
+// count or notification. An archived one is left out of the conversation list until
+// a new message arrives in it.
+type ConversationSettings struct {
+	UserID     int        `json:"userId,omitempty"`
+	GroupID    int        `json:"groupId,omitempty"`
+	Muted      bool       `json:"muted"`
+	MutedUntil *time.Time `json:"mutedUntil,omitempty"` // The mute ends on its own at this time when set
+	Archived   bool       `json:"archived"`
+}
+
+// MessageRequest is a conversation started by a user the receiver has no follow
+// relationship with. It stays out of the receiver's conversations until accepted.
+type MessageRequest struct {
+	Sender      UserItem    `json:"sender"`
+	Status      string      `json:"status"`
+	LastMessage ChatMessage `json:"lastMessage"`
+	CreatedAt   time.Time   `json:"createdAt"`
 }
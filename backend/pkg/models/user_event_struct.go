@@ -0,0 +1,8 @@
+package models
+
+// UserEvent is a real-time event stored so it can be replayed to a reconnecting client
+type UserEvent struct {
+	Seq     int64  `json:"seq"`
+	Type    string `json:"type"`
+	Payload string `json:"payload"` // JSON encoded payload of the event
+}
@@ -5,23 +5,26 @@ import (
 )
 
 type User struct {
-	ID            int       `json:"id"`
-	Username      string    `json:"username"`
-	Email         string    `json:"email"`
-	Password      string    `json:"-"`
-	FirstName     string    `json:"firstName"`
-	LastName      string    `json:"lastName"`
-	Nickname      string    `json:"nickname,omitempty"`
-	DateOfBirth   string    `json:"dateOfBirth"`
-	AboutMe       string    `json:"aboutMe"`
-	AvatarURL     string    `json:"avatarUrl,omitempty"`
-	IsPublic      bool      `json:"isPublic"`
-	CreatedAt     time.Time `json:"createdAt"`
-	Following     int       `json:"following"`
-	Followers     int       `json:"followers"`
-	FollowState   string    `json:"followState"`
-	Notifications int       `json:"notifications"`
-	PostCount     int       `json:"postCount"`
+	ID              int       `json:"id"`
+	Username        string    `json:"username"`
+	Email           string    `json:"email"`
+	Password        string    `json:"-"`
+	FirstName       string    `json:"firstName"`
+	LastName        string    `json:"lastName"`
+	Nickname        string    `json:"nickname,omitempty"`
+	DateOfBirth     string    `json:"dateOfBirth"`
+	AboutMe         string    `json:"aboutMe"`
+	AvatarURL       string    `json:"avatarUrl,omitempty"`
+	IsPublic        bool      `json:"isPublic"`
+	MessageRequests string    `json:"messageRequests,omitempty"` // Who may send message requests: anyone, followers or nobody
+	EmailVerified   bool      `json:"emailVerified"`
+	TwoFactor       bool      `json:"twoFactor"` // Whether logging in asks for a TOTP code
+	CreatedAt       time.Time `json:"createdAt"`
+	Following       int       `json:"following"`
+	Followers       int       `json:"followers"`
+	FollowState     string    `json:"followState"`
+	Notifications   int       `json:"notifications"`
+	PostCount       int       `json:"postCount"`
 }
 
 var UserInfo *User
@@ -51,6 +54,8 @@ func (u *User) SafeUser() map[string]interface{} {
 		"followState":   u.FollowState,
 		"notifications": u.Notifications,
 		"isPublic":      u.IsPublic,
+		"emailVerified": u.EmailVerified,
+		"twoFactor":     u.TwoFactor,
 	}
 }
 
@@ -60,3 +65,41 @@ type FollowRequest struct {
 	FollowedID  int    `json:"followedId"`  // ID of the user performing the action
 	ButtonState string `json:"buttonState"` // New button state (Follow, Unfollow, Pending)
 }
+
+// Presence is the online status of a user as seen by another user
+type Presence struct {
+	UserID     int        `json:"userId"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"lastSeenAt"` // nil when the user hides their status or was never seen
+}
+
+// BlockedUser is a user that someone blocked or muted
+type BlockedUser struct {
+	User      UserItem  `json:"user"`
+	Type      string    `json:"type"` // "block" or "mute"
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Session is a device the user is logged in on. ID is not the session cookie, it
+// only identifies the session in the session management API.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"` // The session making the request
+}
+
+// SecurityEvent is an entry of the audit log of logins, lockouts and account changes
+type SecurityEvent struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"userId,omitempty"` // Unset when the login named no existing account
+	Type       string    `json:"type"`
+	Identifier string    `json:"identifier,omitempty"` // The username or email that was entered
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
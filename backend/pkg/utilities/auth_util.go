@@ -7,8 +7,14 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/gofrs/uuid"
 )
 
+// AttachmentsDir holds chat attachments. Unlike uploads it is not served by the
+// images endpoint, attachments are only sent to the conversation's participants.
+const AttachmentsDir = "../../pkg/db/attachments"
+
 func SaveFile(file multipart.File, header *multipart.FileHeader) (string, error) {
 	// Create the uploads directory if it doesn't exist
 	uploadsDir := "../../pkg/db/uploads"
@@ -36,3 +42,33 @@ func SaveFile(file multipart.File, header *multipart.FileHeader) (string, error)
 	// Return the new filename
 	return filename, nil
 }
+
+// SaveAttachment stores a chat attachment under a random name and returns that name
+func SaveAttachment(file multipart.File) (string, error) {
+	if err := os.MkdirAll(AttachmentsDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	name, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	filename := name.String()
+
+	dst, err := os.Create(filepath.Join(AttachmentsDir, filename))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, file); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return filename, nil
+}
+
+// RemoveAttachment deletes a stored chat attachment
+func RemoveAttachment(filename string) error {
+	return os.Remove(filepath.Join(AttachmentsDir, filepath.Base(filename)))
+}
@@ -0,0 +1,15 @@
+package utilities
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the IP address the request came from, without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
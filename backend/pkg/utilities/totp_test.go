@@ -0,0 +1,65 @@
+package utilities
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, truncated to the last 6 of its 8 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		step, ok := ValidateTOTP(rfcSecret, test.code, time.Unix(test.unix, 0))
+		if !ok {
+			t.Errorf("code %s refused at %d", test.code, test.unix)
+			continue
+		}
+		if want := test.unix / 30; step != want {
+			t.Errorf("code %s at %d matched step %d, want %d", test.code, test.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	// 287082 is the code of step 1, from 30s to 59s
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		unix   int64
+		step   int64 // 0 when the code must be refused
+	}{
+		{name: "same step", secret: rfcSecret, code: "287082", unix: 45, step: 1},
+		{name: "one step early", secret: rfcSecret, code: "287082", unix: 15, step: 1},
+		{name: "one step late", secret: rfcSecret, code: "287082", unix: 89, step: 1},
+		{name: "two steps late", secret: rfcSecret, code: "287082", unix: 90},
+		{name: "two steps early", secret: rfcSecret, code: "081804", unix: 1111111109 - 60},
+		{name: "two steps too late", secret: rfcSecret, code: "081804", unix: 1111111109 + 60},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: "287082", unix: 59, step: 1},
+		{name: "wrong code", secret: rfcSecret, code: "287083", unix: 59},
+		{name: "full 8 digits", secret: rfcSecret, code: "94287082", unix: 59},
+		{name: "too short", secret: rfcSecret, code: "28708", unix: 59},
+		{name: "empty code", secret: rfcSecret, code: "", unix: 59},
+		{name: "invalid secret", secret: "not base32!", code: "287082", unix: 59},
+		{name: "another secret", secret: "JBSWY3DPEHPK3PXP", code: "287082", unix: 59},
+	}
+	for _, test := range tests {
+		step, ok := ValidateTOTP(test.secret, test.code, time.Unix(test.unix, 0))
+		if ok != (test.step != 0) || step != test.step {
+			t.Errorf("%s: got step %d accepted %v, want step %d", test.name, step, ok, test.step)
+		}
+	}
+}
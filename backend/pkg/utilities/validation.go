@@ -4,11 +4,32 @@ import (
 	"backend/pkg/db/sqlite"
 	"backend/pkg/models"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
 	"regexp"
 	"strings"
 	"time"
 )
 
+// Limits on the files attached to a single chat message
+const (
+	MaxAttachments    = 5
+	MaxAttachmentSize = 10 << 20
+)
+
+// Content types accepted as chat attachments, detected from the file contents
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
 func ValidateUser(data models.User) error {
 	// Email validation
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,4}$`)
@@ -89,3 +110,29 @@ func sanitizeInput(input string) string {
 
 	return input
 }
+
+// ValidateAttachment checks the size of an uploaded chat attachment and detects its
+// content type from the first bytes, ignoring the type claimed by the client
+func ValidateAttachment(file multipart.File, header *multipart.FileHeader) (string, error) {
+	if header.Size > MaxAttachmentSize {
+		return "", fmt.Errorf("%s is larger than %d MB", header.Filename, MaxAttachmentSize>>20)
+	}
+	if header.Size == 0 {
+		return "", fmt.Errorf("%s is empty", header.Filename)
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", errors.New("Failed to read attachment")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", errors.New("Failed to read attachment")
+	}
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil || !allowedAttachmentTypes[contentType] {
+		return "", fmt.Errorf("%s is not an allowed file type", header.Filename)
+	}
+	return contentType, nil
+}
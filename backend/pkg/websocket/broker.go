@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// Broker carries hub deliveries between server instances. Every instance
+// publishes targeted sends and logouts through it and delivers to its own
+// connections whatever it receives, including its own publications.
+// Messages published while a subscription is down are lost. subscribed is
+// called each time it is established, so the hub can catch up on them.
+type Broker interface {
+	Publish(message []byte) error
+	Subscribe(handler func(message []byte), subscribed func()) error
+	Close() error
+}
+
+// brokerMessage is the wire format published on the broker.
+type brokerMessage struct {
+	Kind      string          `json:"kind"` // One of the brokerKind constants
+	UserIDs   []int           `json:"userIds,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"` // Marshalled Message sent to the clients
+	Seq       int64           `json:"seq,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Instance  string          `json:"instance,omitempty"` // Instance reporting its presence
+	Online    bool            `json:"online,omitempty"`
+	Reply     bool            `json:"reply,omitempty"`     // The instance just subscribed, the others announce themselves
+	HandedOff bool            `json:"handedOff,omitempty"` // The instance left it to another one to report the users offline
+}
+
+const (
+	brokerKindDirect = "direct"
+	brokerKindLogout = "logout"
+	// UserIDs came online (Online set) or went offline on Instance
+	brokerKindPresence = "presence"
+	// UserIDs are every user connected to Instance
+	brokerKindInstance = "instance"
+)
+
+// publish sends a broker message, falling back to local delivery if the broker
+// is unavailable so connections on this instance still get it.
+func (h *Hub) publish(message brokerMessage) {
+	data, err := json.Marshal(message)
+	if err == nil {
+		err = h.broker.Publish(data)
+	}
+	if err != nil {
+		log.Printf("Error publishing %s message to broker, delivering locally: %v", message.Kind, err)
+		h.deliverFromBroker(message)
+	}
+}
+
+// receive handles a message from the broker subscription.
+func (h *Hub) receive(data []byte) {
+	var message brokerMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Printf("Error decoding broker message: %v", err)
+		return
+	}
+	h.deliverFromBroker(message)
+}
+
+// subscribed makes the connections of this instance catch up on the events
+// published while the broker subscription was down, and asks the other
+// instances who is connected to them.
+func (h *Hub) subscribed() {
+	h.joined <- struct{}{}
+}
+
+// deliverFromBroker hands a broker message to the Run loop.
+func (h *Hub) deliverFromBroker(message brokerMessage) {
+	switch message.Kind {
+	case brokerKindDirect:
+		h.direct <- directMessage{userIDs: message.UserIDs, payload: message.Payload, seq: message.Seq}
+	case brokerKindLogout:
+		h.logout <- message.SessionID
+	case brokerKindPresence, brokerKindInstance:
+		// The Run loop already knows the presence of this instance
+		if message.Instance != h.instance {
+			h.peers <- message
+		}
+	default:
+		log.Printf("Unknown broker message kind %q", message.Kind)
+	}
+}
+
+// MemoryBroker is the Broker of a single instance: publications go straight to
+// the local subscribers.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func(message []byte)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(message []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, handler := range b.handlers {
+		handler(message)
+	}
+	return nil
+}
+
+// Subscribe adds a local subscriber, which never loses its subscription.
+func (b *MemoryBroker) Subscribe(handler func(message []byte), subscribed func()) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = nil
+	return nil
+}
@@ -3,65 +3,191 @@ package websocket
 import (
 	query "backend/pkg/db/queries"
 	"backend/pkg/models"
-	"encoding/json"
 	"log"
+	"time"
 )
 
+// Events pushed to a conversation's participants besides new "chat" messages
+const (
+	MessageTypeReadReceipt  = "read_receipt"  // A reader has caught up to a message
+	MessageTypeChatEdit     = "chat_edit"     // A message's content was edited
+	MessageTypeChatDelete   = "chat_delete"   // A message was deleted and is now a tombstone
+	MessageTypeChatReaction = "chat_reaction" // A participant added, changed or removed their reaction to a message
+	MessageTypeChatMuted    = "chat_muted"    // A new message in a conversation the recipient muted, shown without alerting
+
+	MessageTypeGroupChatSettings = "group_chat_settings" // A group chat's rules, admins or pinned messages changed
+	MessageTypeMessageRequest    = "message_request"     // A message request was accepted or declined
+)
+
+// SendChatToUsers sends a new private message to both participants. A message of a
+// request the receiver has not accepted only reaches the sender's own connections,
+// and raises no unread count.
 func SendChatToUsers(hub *Hub, chatMessage models.ChatMessage) {
-	// Create the message to send
-	message := Message{
-		Type:    "chat",
-		Payload: chatMessage,
+	recipients := privateChatRecipients(chatMessage)
+	if len(recipients) == 1 {
+		SendEventToUsers(hub, recipients, "chat", chatMessage)
+		return
+	}
+	sendChatToRecipients(hub, chatMessage, recipients, []int{chatMessage.ReceiverID})
+}
+
+// privateChatRecipients returns who sees the events of a private message: both
+// participants, or only the sender while it waits in a message request
+func privateChatRecipients(chatMessage models.ChatMessage) []int {
+	status, err := query.GetMessageRequestStatus(chatMessage.SenderID, chatMessage.ReceiverID)
+	if err != nil || status == query.MessageRequestPending || status == query.MessageRequestDeclined {
+		return []int{chatMessage.SenderID} // Better to hold the message back than to reveal a request
 	}
+	return []int{chatMessage.SenderID, chatMessage.ReceiverID}
+}
 
-	// Marshal the message to JSON
-	payload, err := json.Marshal(message)
+func SendGroupChatToUsers(hub *Hub, chatMessage models.ChatMessage) {
+	// Loop through group user IDs
+	group, err := query.GetGroupData(chatMessage.GroupID)
 	if err != nil {
-		log.Printf("Error marshalling chat: %v", err)
+		log.Printf("Error getting group members: %v", err)
 		return
 	}
 
-	// Find the client associated with the userID
-	SendMessageToUser(hub, chatMessage.SenderID, payload)
-	SendMessageToUser(hub, chatMessage.ReceiverID, payload)
+	var groupIds []int
+	for _, member := range group.Members {
+		groupIds = append(groupIds, member.ID)
+	}
+	sendChatToRecipients(hub, chatMessage, groupIds, groupIds)
+}
 
-	err = query.CreateChatNotifications(chatMessage.ID, []int{chatMessage.ReceiverID})
+// sendChatToRecipients stores and sends a new message to every connection of the
+// recipients, and raises the unread count of the notified ones. Recipients who muted
+// the conversation get it as a muted message and no unread count.
+func sendChatToRecipients(hub *Hub, chatMessage models.ChatMessage, recipients []int, notified []int) {
+	partnerID := chatMessage.SenderID
+	if chatMessage.GroupID != 0 {
+		partnerID = 0
+	}
+	muting, err := query.GetUsersMutingConversation(recipients, partnerID, chatMessage.GroupID)
+	if err != nil {
+		muting = map[int]bool{} // Better to alert too much than to lose the message
+	}
+
+	var alerted, muted []int
+	for _, userID := range recipients {
+		if muting[userID] {
+			muted = append(muted, userID)
+		} else {
+			alerted = append(alerted, userID)
+		}
+	}
+	SendEventToUsers(hub, alerted, "chat", chatMessage)
+	if len(muted) > 0 {
+		SendEventToUsers(hub, muted, MessageTypeChatMuted, chatMessage)
+	}
+
+	var notifiedIds []int
+	for _, userID := range notified {
+		if !muting[userID] {
+			notifiedIds = append(notifiedIds, userID)
+		}
+	}
+	if len(notifiedIds) == 0 {
+		return
+	}
+	err = query.CreateChatNotifications(chatMessage.ID, notifiedIds)
 	if err != nil {
 		log.Printf("Error sending Notifications: %v", err)
 		return
 	}
 }
 
-func SendGroupChatToUsers(hub *Hub, chatMessage models.ChatMessage) {
-	// Create the message to send
-	message := Message{
-		Type:    "chat",
-		Payload: chatMessage,
+// SendChatUpdateToUsers pushes an edited or deleted message to everyone in its
+// conversation, so open chats replace the message in place.
+func SendChatUpdateToUsers(hub *Hub, eventType string, chatMessage models.ChatMessage) {
+	var recipients []int
+	if chatMessage.GroupID == 0 {
+		recipients = privateChatRecipients(chatMessage)
+	} else {
+		group, err := query.GetGroupData(chatMessage.GroupID)
+		if err != nil {
+			log.Printf("Error getting group members: %v", err)
+			return
+		}
+		recipients = nil
+		for _, member := range group.Members {
+			recipients = append(recipients, member.ID)
+		}
 	}
+	SendEventToUsers(hub, recipients, eventType, chatMessage)
+}
 
-	// Marshal the message to JSON
-	payload, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshalling chat: %v", err)
-		return
+// MarkChatRead records that readerID has read the private chat with partnerID, or
+// the group chat groupID, up to upToMessageID (everything when 0). The receipt is
+// pushed to the partner or to every group member, and to the reader's other
+// devices so they clear their unread counters. Callers check group membership.
+func MarkChatRead(hub *Hub, readerID int, partnerID int, groupID int, upToMessageID int) error {
+	receipt := models.ReadReceipt{
+		ReaderID: readerID,
+		UserID:   partnerID,
+		GroupID:  groupID,
+		ReadAt:   time.Now(),
 	}
 
-	// Loop through group user IDs
-	group, err := query.GetGroupData(chatMessage.GroupID)
+	var err error
+	recipients := []int{partnerID, readerID}
+	if groupID != 0 {
+		receipt.MessageID, err = query.MarkGroupChatReadUpTo(readerID, groupID, upToMessageID, receipt.ReadAt)
+		if err != nil {
+			return err
+		}
+		if receipt.MessageID == 0 {
+			return nil
+		}
+
+		group, err := query.GetGroupData(groupID)
+		if err != nil {
+			log.Printf("Error getting group members: %v", err)
+			return err
+		}
+		recipients = nil
+		for _, member := range group.Members {
+			recipients = append(recipients, member.ID)
+		}
+	} else {
+		receipt.MessageID, err = query.MarkChatReadUpTo(readerID, partnerID, upToMessageID, receipt.ReadAt)
+		if err != nil {
+			return err
+		}
+		if receipt.MessageID == 0 {
+			return nil // Already read, nobody needs to be told again
+		}
+	}
+
+	SendEventToUsers(hub, recipients, MessageTypeReadReceipt, receipt)
+	return nil
+}
+
+// SendGroupChatSettingsToMembers pushes the new settings of a group chat to every member
+func SendGroupChatSettingsToMembers(hub *Hub, settings models.GroupChatSettings) {
+	group, err := query.GetGroupData(settings.GroupID)
 	if err != nil {
 		log.Printf("Error getting group members: %v", err)
 		return
 	}
-
-	var groupIds []int
+	var memberIds []int
 	for _, member := range group.Members {
-		groupIds = append(groupIds, member.ID)
-		SendMessageToUser(hub, member.ID, payload)
+		memberIds = append(memberIds, member.ID)
 	}
+	SendEventToUsers(hub, memberIds, MessageTypeGroupChatSettings, settings)
+}
 
-	err = query.CreateChatNotifications(chatMessage.ID, groupIds)
-	if err != nil {
-		log.Printf("Error sending Notifications: %v", err)
-		return
+// SendMessageRequestUpdate tells the receiver's devices that they accepted or declined
+// the message request of senderID. The sender only learns about an acceptance.
+func SendMessageRequestUpdate(hub *Hub, senderID int, receiverID int, status string) {
+	recipients := []int{receiverID}
+	if status == query.MessageRequestAccepted {
+		recipients = append(recipients, senderID)
 	}
+	SendEventToUsers(hub, recipients, MessageTypeMessageRequest, map[string]interface{}{
+		"senderId":   senderID,
+		"receiverId": receiverID,
+		"status":     status,
+	})
 }
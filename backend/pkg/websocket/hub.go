@@ -1,42 +1,328 @@
 package websocket
 
+import (
+    "log"
+    "time"
+)
 
+// Hub owns the map of connected clients. Only the Run loop reads or writes
+// it, every other goroutine talks to the hub through its channels. Targeted
+// sends and logouts go through the broker so that every server instance
+// delivers them to the connections it holds. Instances also tell each other
+// which users they hold connections of, so presence is the same on all of them.
 type Hub struct {
-    clients    map[int]*Client // Map user IDs to Client instances
-    broadcast  chan []byte
+    broker     Broker
+    instance   string // Random ID of this instance on the broker
+    clients    map[int]map[*Client]bool // Map user IDs to the set of their open connections
+    reply      chan clientMessage // Replies addressed to a single connection
+    direct     chan directMessage // Payloads targeted at specific users
+    presence   chan presenceQuery // Questions about who is connected
     register   chan *Client
     unregister chan *Client
+    logout     chan string // Session IDs whose connections must be closed
+    resume     chan *Client     // Connections starting a replay of missed events
+    replayed   chan replayBatch // Missed events loaded for a resuming connection
+    typing     chan typingEvent // Typing indicators started or stopped by local clients
+    joined     chan struct{}    // The broker subscription was established, anything before it was missed
+    peers      chan brokerMessage // Presence reported by other instances
+    outbox     chan brokerMessage // Presence of this instance waiting to be published
+    typingOut  chan brokerMessage // Typing indicators waiting to be sent, in the order they changed
+    typists    map[typingKey]typingState
+    remote     map[string]*peerInstance // Other instances by ID, with the users they hold
+    handedOff  map[int]bool // Users whose last connection here closed while they were connected elsewhere
+
+    // Called in its own goroutine when a user comes online or goes offline
+    presenceChanged func(userID int)
+}
+
+// directMessage is a payload addressed to every connection of the given users.
+// Sequenced events carry the seq they were stored with, 0 otherwise.
+type directMessage struct {
+    userIDs []int
+    payload []byte
+    seq     int64
+}
+
+// replayBatch holds the stored events a connection missed, followed by the
+// message telling it where the replay ended.
+type replayBatch struct {
+    client   *Client
+    messages [][]byte
+    lastSeq  int64  // Highest sequence included in the replay
+    done     []byte // "resumed" or "resync" message sent after the events
+}
+
+// clientMessage is a payload addressed to one specific connection.
+type clientMessage struct {
+    client  *Client
+    payload []byte
+}
+
+// presenceQuery asks the Run loop which of the given users have at least one
+// open connection. A nil userIDs slice asks for every connected user.
+type presenceQuery struct {
+    userIDs []int
+    reply   chan []int
 }
 
+// NewHub creates a hub for a single server instance.
 func NewHub() *Hub {
-    return &Hub{
-        broadcast:  make(chan []byte),
+    return NewHubWithBroker(NewMemoryBroker())
+}
+
+// NewHubWithBroker creates a hub that shares deliveries with other instances through broker.
+func NewHubWithBroker(broker Broker) *Hub {
+    hub := &Hub{
+        broker:     broker,
+        instance:   newInstanceID(),
+        reply:      make(chan clientMessage),
+        direct:     make(chan directMessage),
+        presence:   make(chan presenceQuery),
         register:   make(chan *Client),
         unregister: make(chan *Client),
-        clients:    make(map[int]*Client), // Updated to map user IDs to Client instances
+        logout:     make(chan string),
+        resume:     make(chan *Client),
+        replayed:   make(chan replayBatch),
+        typing:     make(chan typingEvent),
+        joined:     make(chan struct{}),
+        peers:      make(chan brokerMessage),
+        outbox:     make(chan brokerMessage, sendBufferSize),
+        typingOut:  make(chan brokerMessage, sendBufferSize),
+        typists:    make(map[typingKey]typingState),
+        remote:     make(map[string]*peerInstance),
+        handedOff:  make(map[int]bool),
+        clients:    make(map[int]map[*Client]bool), // One entry per user, one set member per tab or device
+    }
+    hub.presenceChanged = func(userID int) { userPresenceChanged(hub, userID) }
+    if err := broker.Subscribe(hub.receive, hub.subscribed); err != nil {
+        log.Printf("Error subscribing hub to broker: %v", err)
     }
+    return hub
+}
+
+// Close stops the hub's broker subscription.
+func (h *Hub) Close() error {
+    return h.broker.Close()
 }
 
 func (h *Hub) Run() {
+    typingSweep := time.NewTicker(typingSweepPeriod)
+    defer typingSweep.Stop()
+    presenceAnnounce := time.NewTicker(presenceAnnouncePeriod)
+    defer presenceAnnounce.Stop()
+
+    go h.publishPresence()
+    go h.publishTyping()
+
     for {
         select {
         case client := <-h.register:
-            h.clients[client.userID] = client // Register the client using userID
+            // Add the connection next to any the user already has open
+            if h.clients[client.userID] == nil {
+                h.clients[client.userID] = make(map[*Client]bool)
+                h.userConnected(client.userID)
+            }
+            h.clients[client.userID][client] = true
         case client := <-h.unregister:
-            if _, ok := h.clients[client.userID]; ok {
-                delete(h.clients, client.userID) // Unregister the client using userID
-                close(client.send) // Close the send channel
-            }
-        case message := <-h.broadcast:
-            // Iterate over the clients map to send the message
-            for userID, client := range h.clients {
-                select {
-                case client.send <- message: // Send the message to the client's send channel
-                default:
-                    close(client.send) // Close the send channel if not ready
-                    delete(h.clients, userID) // Remove the client from the map
+            // The client may already have been dropped by a logout or a failed send
+            if _, ok := h.clients[client.userID][client]; ok {
+                h.removeClient(client)
+            }
+        case sessionID := <-h.logout:
+            // Drop every connection that was opened with the logged out session
+            for _, connections := range h.clients {
+                for client := range connections {
+                    if client.sessionID == sessionID {
+                        h.removeClient(client) // writePump sends a close frame and closes the socket
+                    }
                 }
             }
+        case message := <-h.direct:
+            for _, userID := range message.userIDs {
+                h.sendToUser(userID, message)
+            }
+        case client := <-h.resume:
+            // Hold live messages for the connection until its replay arrives
+            if _, ok := h.clients[client.userID][client]; ok {
+                client.replaying = true
+                client.backlog = nil
+            }
+        case batch := <-h.replayed:
+            if _, ok := h.clients[batch.client.userID][batch.client]; ok {
+                h.finishReplay(batch)
+            }
+        case <-h.joined:
+            h.announcePresence(true)
+            // Replay what the broker missed to every connection that knows its position
+            for _, connections := range h.clients {
+                for client := range connections {
+                    if client.replaying {
+                        client.resumeAfterReplay = true
+                    } else if client.caughtUp {
+                        go client.resumeFrom(client.lastSeq, false)
+                    }
+                }
+            }
+        case event := <-h.typing:
+            h.setTyping(event)
+        case now := <-typingSweep.C:
+            h.expireTyping(now)
+        case message := <-h.peers:
+            h.updatePeer(message, time.Now())
+        case now := <-presenceAnnounce.C:
+            h.announcePresence(false)
+            h.expirePeers(now)
+        case query := <-h.presence:
+            query.reply <- h.onlineUsers(query.userIDs)
+        case message := <-h.reply:
+            // Skip replies for connections that were removed in the meantime
+            if _, ok := h.clients[message.client.userID][message.client]; ok {
+                h.deliver(message.client, message.payload)
+            }
         }
     }
 }
+
+// sendToUser queues the message on every connection of the user without blocking the loop.
+func (h *Hub) sendToUser(userID int, message directMessage) {
+    connections, ok := h.clients[userID]
+    if !ok {
+        log.Printf("User with ID %d not connected", userID)
+        return
+    }
+    for client := range connections {
+        if client.replaying {
+            // Delivered in order once the replay is written
+            if len(client.backlog) >= sendBufferSize {
+                h.evictClient(client)
+                continue
+            }
+            client.backlog = append(client.backlog, message)
+            continue
+        }
+        if h.deliver(client, message.payload) {
+            log.Printf("Payload sent to user %d", userID)
+            if message.seq > client.lastSeq {
+                client.lastSeq = message.seq
+            }
+        }
+    }
+}
+
+// deliver queues a payload on one connection. It returns false if the connection's
+// buffer was full and it has been evicted.
+func (h *Hub) deliver(client *Client, payload []byte) bool {
+    select {
+    case client.send <- payload:
+        return true
+    default:
+        h.evictClient(client)
+        return false
+    }
+}
+
+// finishReplay writes the missed events, then the live messages held during the
+// replay that were not already part of it.
+func (h *Hub) finishReplay(batch replayBatch) {
+    client := batch.client
+    backlog := client.backlog
+    client.replaying = false
+    client.backlog = nil
+
+    for _, payload := range batch.messages {
+        if !h.deliver(client, payload) {
+            return
+        }
+    }
+    if !h.deliver(client, batch.done) {
+        return
+    }
+    client.caughtUp = true
+    client.lastSeq = batch.lastSeq
+    for _, message := range backlog {
+        if message.seq != 0 && message.seq <= batch.lastSeq {
+            continue
+        }
+        if !h.deliver(client, message.payload) {
+            return
+        }
+        if message.seq > client.lastSeq {
+            client.lastSeq = message.seq
+        }
+    }
+
+    // The broker lost messages while this replay was loaded, it may lack some of them
+    if client.resumeAfterReplay {
+        client.resumeAfterReplay = false
+        go client.resumeFrom(client.lastSeq, false)
+    }
+}
+
+// evictClient drops a connection that stopped draining its send buffer. Closing
+// it makes the client reconnect and catch up, rather than silently missing messages.
+func (h *Hub) evictClient(client *Client) {
+    log.Printf("Evicting slow WebSocket connection of user %d", client.userID)
+    h.removeClient(client)
+}
+
+// onlineUsers returns the IDs from userIDs that have an open connection on any
+// instance, or every connected user when userIDs is nil.
+func (h *Hub) onlineUsers(userIDs []int) []int {
+    online := []int{}
+    if userIDs == nil {
+        for userID := range h.clients {
+            online = append(online, userID)
+        }
+        counted := make(map[int]bool)
+        for _, peer := range h.remote {
+            for userID := range peer.users {
+                if h.clients[userID] == nil && !counted[userID] {
+                    counted[userID] = true
+                    online = append(online, userID)
+                }
+            }
+        }
+        return online
+    }
+    for _, userID := range userIDs {
+        if h.isOnline(userID) {
+            online = append(online, userID)
+        }
+    }
+    return online
+}
+
+// removeClient deletes a single connection and closes its send channel.
+// The user's entry is removed once their last connection is gone.
+func (h *Hub) removeClient(client *Client) {
+    delete(h.clients[client.userID], client)
+    if len(h.clients[client.userID]) == 0 {
+        delete(h.clients, client.userID)
+        h.stopUserTyping(client.userID)
+        h.userDisconnected(client.userID)
+    }
+    close(client.send)
+}
+
+// DisconnectSession closes all WebSocket connections authenticated with the given session.
+func DisconnectSession(hub *Hub, sessionID string) {
+    hub.publish(brokerMessage{Kind: brokerKindLogout, SessionID: sessionID})
+}
+
+// SendMessageToUsers queues the payload for every connection of each user.
+func SendMessageToUsers(hub *Hub, userIDs []int, payload []byte) {
+    hub.publish(brokerMessage{Kind: brokerKindDirect, UserIDs: userIDs, Payload: payload})
+}
+
+// IsUserOnline reports whether the user has at least one open connection.
+func IsUserOnline(hub *Hub, userID int) bool {
+    return len(GetOnlineUsers(hub, []int{userID})) > 0
+}
+
+// GetOnlineUsers returns the subset of userIDs connected to any instance.
+// Passing nil returns every connected user.
+func GetOnlineUsers(hub *Hub, userIDs []int) []int {
+    reply := make(chan []int, 1)
+    hub.presence <- presenceQuery{userIDs: userIDs, reply: reply}
+    return <-reply
+}
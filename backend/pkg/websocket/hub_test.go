@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestHub starts a hub whose presence changes are only counted, so the tests
+// need no database.
+func newTestHub(t *testing.T) (*Hub, *int64) {
+	t.Helper()
+	var presenceChanges int64
+	hub := NewHub()
+	hub.presenceChanged = func(int) { atomic.AddInt64(&presenceChanges, 1) }
+	t.Cleanup(func() { hub.Close() })
+	go hub.Run()
+	return hub, &presenceChanges
+}
+
+// testClient is a connection without a socket: a goroutine drains its send
+// buffer like writePump would, counting what it receives.
+type testClient struct {
+	*Client
+	received int64
+	closed   chan struct{}
+}
+
+func newTestClient(hub *Hub, userID int, sessionID string, bufferSize int) *testClient {
+	return &testClient{
+		Client: &Client{
+			hub:       hub,
+			send:      make(chan []byte, bufferSize),
+			userID:    userID,
+			sessionID: sessionID,
+		},
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *testClient) drain() {
+	for range c.send {
+		atomic.AddInt64(&c.received, 1)
+	}
+	close(c.closed)
+}
+
+func (c *testClient) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventually fails the test if cond is still false after a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubConcurrentClients(t *testing.T) {
+	hub, presenceChanges := newTestHub(t)
+
+	// Three connections per user. Each connection sends to its own user, then the
+	// first of every three disconnects, the second is logged out and the third stays.
+	const users = 100
+	const connections = 3 * users
+	clients := make([]*testClient, connections)
+	for i := range clients {
+		clients[i] = newTestClient(hub, i%users+1, fmt.Sprintf("session-%d", i), sendBufferSize)
+		go clients[i].drain()
+	}
+
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *testClient) {
+			defer wg.Done()
+			hub.register <- client.Client
+			SendMessageToUser(hub, client.userID, []byte(`{"type":"direct"}`))
+			SendMessageToUsers(hub, []int{client.userID, users + 1}, []byte(`{"type":"group"}`))
+			if !IsUserOnline(hub, client.userID) {
+				t.Errorf("user %d offline right after connecting", client.userID)
+			}
+			GetOnlineUsers(hub, nil)
+
+			switch i / users {
+			case 0:
+				hub.unregister <- client.Client
+			case 1:
+				DisconnectSession(hub, client.sessionID)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	for i, client := range clients {
+		if i/users == 2 {
+			continue
+		}
+		eventually(t, fmt.Sprintf("connection %d to close", i), client.isClosed)
+		// Closing twice would panic, the hub must ignore the late unregister
+		hub.unregister <- client.Client
+	}
+
+	online := GetOnlineUsers(hub, nil)
+	sort.Ints(online)
+	if len(online) != users {
+		t.Fatalf("got %d online users, want %d", len(online), users)
+	}
+	for i, userID := range online {
+		if userID != i+1 {
+			t.Fatalf("online users = %v, want 1..%d", online, users)
+		}
+	}
+	if got := GetOnlineUsers(hub, []int{1, users + 1, users + 2}); len(got) != 1 || got[0] != 1 {
+		t.Errorf("GetOnlineUsers(1, %d, %d) = %v, want [1]", users+1, users+2, got)
+	}
+
+	for i, client := range clients[2*users:] {
+		if client.isClosed() {
+			t.Fatalf("connection %d was closed", 2*users+i)
+		}
+		// Its own two messages at least, delivered before the hub answered the queries above
+		eventually(t, fmt.Sprintf("connection %d to receive its messages", 2*users+i), func() bool {
+			return atomic.LoadInt64(&client.received) >= 2
+		})
+	}
+
+	// Every user came online at least once, more if all their connections closed in between
+	if got := atomic.LoadInt64(presenceChanges); got < users {
+		t.Errorf("got %d presence changes, want at least %d", got, users)
+	}
+
+	for _, client := range clients[2*users:] {
+		hub.unregister <- client.Client
+		<-client.closed
+	}
+	if online := GetOnlineUsers(hub, nil); len(online) != 0 {
+		t.Errorf("users %v still online after every connection closed", online)
+	}
+}
+
+func TestHubEvictsSlowClient(t *testing.T) {
+	hub, _ := newTestHub(t)
+
+	// Nobody drains the slow connection, so its single slot fills up
+	slow := newTestClient(hub, 1, "slow", 1)
+	fast := newTestClient(hub, 1, "fast", sendBufferSize)
+	go fast.drain()
+	hub.register <- slow.Client
+	hub.register <- fast.Client
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			SendMessageToUser(hub, 1, []byte(`{"type":"direct"}`))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sending to a slow connection blocked the hub")
+	}
+
+	// The buffered message is still written, then the channel is closed
+	if _, ok := <-slow.send; !ok {
+		t.Fatal("slow connection closed before its buffered message")
+	}
+	if _, ok := <-slow.send; ok {
+		t.Fatal("slow connection was not evicted")
+	}
+
+	if !IsUserOnline(hub, 1) {
+		t.Fatal("user went offline with a connection still open")
+	}
+	eventually(t, "the fast connection to receive every message", func() bool {
+		return atomic.LoadInt64(&fast.received) == 10
+	})
+
+	hub.unregister <- slow.Client // Late unregister from readPump is ignored
+	hub.unregister <- fast.Client
+	if IsUserOnline(hub, 1) {
+		t.Error("user still online after their connections closed")
+	}
+}
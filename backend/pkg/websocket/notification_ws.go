@@ -1,66 +1,19 @@
 package websocket
 
 import (
+	query "backend/pkg/db/queries"
 	"backend/pkg/models"
-	"encoding/json"
-	"log"
 )
 
 func SendNotificationToUser(hub *Hub, userID int, notification models.Notification) {
-	// Create the message to send
-	message := Message{
-		Type:    "notification",
-		Payload: notification,
-	}
-
-	// Marshal the message to JSON
-	payload, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshalling notification: %v", err)
-		return
-	}
-
-	// Find the client associated with the userID
-	client, ok := hub.clients[userID]
-	if !ok {
-		log.Printf("User with ID %d not connected", userID)
+	// Nothing reaches a user from someone they blocked or who blocked them
+	if blocked, err := query.IsBlocked(notification.NotifyingUserId, userID); err != nil || blocked {
 		return
 	}
-
-	// Send the message to the specific user's WebSocket connection
-	select {
-	case client.send <- payload:
-		log.Printf("Notification sent to user %d", userID)
-	default:
-		log.Printf("User %d's WebSocket connection is not ready", userID)
-	}
+	// Store and send the notification to every connection of the user
+	SendEventToUsers(hub, []int{userID}, "notification", notification)
 }
 
 func SendDeNotificationToUser(hub *Hub, userID int) {
-	// Create the message to send
-	message := Message{
-		Type: "denotification",
-	}
-
-	// Marshal the message to JSON
-	payload, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshalling notification: %v", err)
-		return
-	}
-
-	// Find the client associated with the userID
-	client, ok := hub.clients[userID]
-	if !ok {
-		log.Printf("User with ID %d not connected", userID)
-		return
-	}
-
-	// Send the message to the specific user's WebSocket connection
-	select {
-	case client.send <- payload:
-		log.Printf("Notification sent to user %d", userID)
-	default:
-		log.Printf("User %d's WebSocket connection is not ready", userID)
-	}
+	SendEventToUsers(hub, []int{userID}, "denotification", nil)
 }
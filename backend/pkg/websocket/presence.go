@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/models"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const MessageTypePresence = "presence"
+
+const (
+	// How often every instance tells the others which users it holds connections of
+	presenceAnnouncePeriod = 30 * time.Second
+
+	// An instance not heard from for this long is assumed gone, with its users offline
+	presencePeerTimeout = 3 * presenceAnnouncePeriod
+
+	// Publishing presence is retried this often, this far apart, while the broker is unavailable
+	presencePublishAttempts = 5
+	presenceRetryDelay      = time.Second
+)
+
+// peerInstance is what a hub knows of another instance sharing its broker.
+type peerInstance struct {
+	users  map[int]bool
+	seenAt time.Time
+}
+
+// newInstanceID returns a random ID telling this instance apart on the broker.
+func newInstanceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("Error generating instance ID: %v", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// isOnline reports whether the user has a connection on any instance. Only the
+// Run loop may call it, like the other hub methods below.
+func (h *Hub) isOnline(userID int) bool {
+	return h.clients[userID] != nil || h.onlineElsewhere(userID)
+}
+
+// userConnected handles the first connection of a user on this instance. Only
+// the first one across all instances changes what others see.
+func (h *Hub) userConnected(userID int) {
+	delete(h.handedOff, userID)
+	h.queuePresence(brokerMessage{Kind: brokerKindPresence, Instance: h.instance, UserIDs: []int{userID}, Online: true})
+	if h.onlineElsewhere(userID) {
+		return
+	}
+	go h.presenceChanged(userID)
+}
+
+// userDisconnected handles the last connection of a user on this instance
+// closing. If they are still connected elsewhere nothing changes for now. Should
+// that instance see them leave at the same time, each counting on the other, this
+// one reports them offline once it hears of it.
+func (h *Hub) userDisconnected(userID int) {
+	handOff := h.onlineElsewhere(userID)
+	h.queuePresence(brokerMessage{Kind: brokerKindPresence, Instance: h.instance, UserIDs: []int{userID}, HandedOff: handOff})
+	if handOff {
+		h.handedOff[userID] = true
+		return
+	}
+	go h.presenceChanged(userID)
+}
+
+func (h *Hub) onlineElsewhere(userID int) bool {
+	for _, peer := range h.remote {
+		if peer.users[userID] {
+			return true
+		}
+	}
+	return false
+}
+
+// updatePeer applies the presence reported by another instance.
+func (h *Hub) updatePeer(message brokerMessage, now time.Time) {
+	peer := h.remote[message.Instance]
+	if peer == nil {
+		peer = &peerInstance{users: make(map[int]bool)}
+		h.remote[message.Instance] = peer
+	}
+	if peer.seenAt.IsZero() || message.Reply {
+		// The instance does not know who is connected here yet
+		h.announcePresence(false)
+	}
+	peer.seenAt = now
+
+	var left []int // Users the instance no longer holds, which it may not have reported offline
+	switch message.Kind {
+	case brokerKindPresence:
+		for _, userID := range message.UserIDs {
+			if message.Online {
+				peer.users[userID] = true
+			} else if peer.users[userID] {
+				delete(peer.users, userID)
+				if message.HandedOff {
+					left = append(left, userID)
+				}
+			}
+		}
+	case brokerKindInstance:
+		users := make(map[int]bool, len(message.UserIDs))
+		for _, userID := range message.UserIDs {
+			users[userID] = true
+		}
+		for userID := range peer.users {
+			if !users[userID] {
+				left = append(left, userID)
+			}
+		}
+		peer.users = users
+	}
+
+	// Both instances counted on the other one to report them offline
+	for _, userID := range left {
+		if h.handedOff[userID] && !h.isOnline(userID) {
+			delete(h.handedOff, userID)
+			go h.presenceChanged(userID)
+		}
+	}
+}
+
+// expirePeers forgets the instances that stopped announcing themselves, the
+// users only they held went offline without anyone telling.
+func (h *Hub) expirePeers(now time.Time) {
+	for id, peer := range h.remote {
+		if now.Sub(peer.seenAt) < presencePeerTimeout {
+			continue
+		}
+		log.Printf("Instance %s stopped announcing its presence, its users are now offline", id)
+		delete(h.remote, id)
+		for userID := range peer.users {
+			if !h.isOnline(userID) {
+				delete(h.handedOff, userID)
+				go h.presenceChanged(userID)
+			}
+		}
+	}
+}
+
+// announcePresence tells the other instances every user connected here. With
+// askReplies they answer with theirs.
+func (h *Hub) announcePresence(askReplies bool) {
+	userIDs := make([]int, 0, len(h.clients))
+	for userID := range h.clients {
+		userIDs = append(userIDs, userID)
+	}
+	h.queuePresence(brokerMessage{Kind: brokerKindInstance, Instance: h.instance, UserIDs: userIDs, Reply: askReplies})
+}
+
+// queuePresence hands a presence message to publishPresence without blocking
+// the Run loop. If the queue is full the next announcement corrects the others.
+func (h *Hub) queuePresence(message brokerMessage) {
+	select {
+	case h.outbox <- message:
+	default:
+		log.Printf("Presence queue full, dropping %s message", message.Kind)
+	}
+}
+
+// publishPresence publishes the queued presence messages in order. It runs in
+// its own goroutine for as long as the hub. Delivering them locally is of no
+// use, so a failed publish is retried a few times before it is given up on
+// until the next announcement.
+func (h *Hub) publishPresence() {
+	for message := range h.outbox {
+		data, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Error marshalling %s message: %v", message.Kind, err)
+			continue
+		}
+		for attempt := 1; ; attempt++ {
+			err := h.broker.Publish(data)
+			if err == nil {
+				break
+			}
+			if attempt == presencePublishAttempts {
+				log.Printf("Error publishing %s message to broker, dropping it: %v", message.Kind, err)
+				break
+			}
+			time.Sleep(presenceRetryDelay)
+		}
+	}
+}
+
+// userPresenceChanged runs when a user comes online or goes offline, as far as
+// this instance can tell. It re-reads the hub instead of trusting the caller, so
+// quick connect/disconnect sequences still settle on the real state. Users who
+// hide their status look offline all along, so nothing is sent for them.
+func userPresenceChanged(hub *Hub, userID int) {
+	if err := query.UpdateLastSeen(userID); err != nil {
+		log.Printf("Error updating last seen of user %d: %v", userID, err)
+	}
+	_, showPresence, err := query.GetLastSeen(userID)
+	if err != nil {
+		log.Printf("Error getting presence setting of user %d: %v", userID, err)
+		return
+	}
+	if showPresence {
+		BroadcastPresence(hub, userID)
+	}
+}
+
+// BroadcastPresence pushes the user's current status to their followers and
+// chat partners. Users who hide their status are reported offline, which is
+// only sent once, when they change the setting. Presence events are transient
+// and are not stored for replay.
+func BroadcastPresence(hub *Hub, userID int) {
+	presence, err := GetUserPresence(hub, userID)
+	if err != nil {
+		log.Printf("Error getting presence of user %d: %v", userID, err)
+		return
+	}
+
+	audience, err := query.GetPresenceAudience(userID)
+	if err != nil {
+		log.Printf("Error getting presence audience of user %d: %v", userID, err)
+		return
+	}
+	if len(audience) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(Message{Type: MessageTypePresence, Payload: presence})
+	if err != nil {
+		log.Printf("Error marshalling presence: %v", err)
+		return
+	}
+	SendMessageToUsers(hub, audience, payload)
+}
+
+// GetUserPresence returns the status of a user as others may see it.
+func GetUserPresence(hub *Hub, userID int) (models.Presence, error) {
+	lastSeen, showPresence, err := query.GetLastSeen(userID)
+	if err != nil {
+		return models.Presence{}, err
+	}
+	if !showPresence {
+		return models.Presence{UserID: userID}, nil
+	}
+	return models.Presence{
+		UserID:     userID,
+		Online:     IsUserOnline(hub, userID),
+		LastSeenAt: lastSeen,
+	}, nil
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/db/sqlite"
+	"backend/pkg/db/sqlite/sqlitetest"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPresenceAcrossInstances(t *testing.T) {
+	server := newFakeRedis(t)
+	first, firstChanges := newRedisTestHub(t, server)
+	second, secondChanges := newRedisTestHub(t, server)
+	eventually(t, "both hubs to subscribe", func() bool { return server.subscriberCount() == 2 })
+
+	onFirst := newTestClient(first, 1, "first-session", sendBufferSize)
+	first.register <- onFirst.Client
+	eventually(t, "the second instance to see user 1", func() bool { return IsUserOnline(second, 1) })
+	if online := GetOnlineUsers(second, nil); len(online) != 1 || online[0] != 1 {
+		t.Errorf("second instance sees %v online, want [1]", online)
+	}
+
+	// Connecting on the second instance too changes nothing for the others
+	onSecond := newTestClient(second, 1, "second-session", sendBufferSize)
+	second.register <- onSecond.Client
+	// Presence is published in order, once user 99 is seen so is user 1
+	second.register <- newTestClient(second, 99, "probe-session", sendBufferSize).Client
+	eventually(t, "the first instance to see user 99", func() bool { return IsUserOnline(first, 99) })
+	first.unregister <- onFirst.Client
+	if !IsUserOnline(first, 1) {
+		t.Error("first instance sees user 1 offline while they are connected to the second")
+	}
+	first.register <- newTestClient(first, 98, "probe-session", sendBufferSize).Client
+	eventually(t, "the second instance to see user 98", func() bool { return IsUserOnline(second, 98) })
+
+	// Leaving the last instance takes them offline everywhere
+	second.unregister <- onSecond.Client
+	eventually(t, "the first instance to see user 1 leave", func() bool { return !IsUserOnline(first, 1) })
+
+	// Users 1 and 98 coming online on the first instance, users 99 and 1 on the second
+	if got := atomic.LoadInt64(firstChanges); got != 2 {
+		t.Errorf("first instance reported %d presence changes, want 2", got)
+	}
+	eventually(t, "the second instance to report user 1 offline", func() bool {
+		return atomic.LoadInt64(secondChanges) == 2
+	})
+
+	// An instance started later learns who is already connected
+	onFirst = newTestClient(first, 2, "first-session", sendBufferSize)
+	first.register <- onFirst.Client
+	third, _ := newRedisTestHub(t, server)
+	eventually(t, "the new instance to see user 2", func() bool { return IsUserOnline(third, 2) })
+}
+
+// TestHubPeerPresence drives the presence bookkeeping of a hub directly, without
+// its Run loop, as if other instances reported their users.
+func TestHubPeerPresence(t *testing.T) {
+	hub := NewHub()
+	t.Cleanup(func() { hub.Close() })
+	changes := make(chan int, 10)
+	hub.presenceChanged = func(userID int) { changes <- userID }
+
+	expectChange := func(userID int) {
+		t.Helper()
+		select {
+		case got := <-changes:
+			if got != userID {
+				t.Fatalf("presence of user %d changed, want user %d", got, userID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("presence of user %d did not change", userID)
+		}
+	}
+	expectNoChange := func() {
+		t.Helper()
+		select {
+		case got := <-changes:
+			t.Fatalf("presence of user %d changed unexpectedly", got)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	online := func() []int {
+		users := hub.onlineUsers(nil)
+		sort.Ints(users)
+		return users
+	}
+
+	start := time.Now()
+	hub.updatePeer(brokerMessage{Kind: brokerKindInstance, Instance: "a", UserIDs: []int{1, 2}}, start)
+	hub.updatePeer(brokerMessage{Kind: brokerKindPresence, Instance: "b", UserIDs: []int{3}, Online: true}, start)
+	if got := online(); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Fatalf("online users = %v, want [1 2 3]", got)
+	}
+	expectNoChange() // The instances they connected to report it
+
+	// User 2 leaves here, then on instance a, which reports it
+	hub.clients[2] = map[*Client]bool{}
+	hub.userConnected(2)
+	delete(hub.clients, 2)
+	hub.userDisconnected(2)
+	hub.updatePeer(brokerMessage{Kind: brokerKindPresence, Instance: "a", UserIDs: []int{2}}, start)
+	expectNoChange()
+
+	// User 1 leaves here and on instance a at the same time, each counting on the other
+	hub.clients[1] = map[*Client]bool{}
+	hub.userConnected(1)
+	expectNoChange()
+	delete(hub.clients, 1)
+	hub.userDisconnected(1)
+	expectNoChange()
+	hub.updatePeer(brokerMessage{Kind: brokerKindPresence, Instance: "a", UserIDs: []int{1}, HandedOff: true}, start)
+	expectChange(1)
+	hub.updatePeer(brokerMessage{Kind: brokerKindPresence, Instance: "a", UserIDs: []int{1}, Online: true}, start)
+
+	// A user missing from an announcement left, the instance reports it itself
+	hub.updatePeer(brokerMessage{Kind: brokerKindInstance, Instance: "a"}, start.Add(presenceAnnouncePeriod))
+	expectNoChange()
+	if got := hub.onlineUsers([]int{1, 2, 3}); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("online users = %v, want [3]", got)
+	}
+
+	// Instance b stops announcing, its users are taken offline
+	hub.expirePeers(start.Add(presencePeerTimeout))
+	expectChange(3)
+	if got := online(); len(got) != 0 {
+		t.Errorf("online users = %v after every instance went quiet or empty, want none", got)
+	}
+	if _, ok := hub.remote["a"]; !ok {
+		t.Error("instance a expired although it announced itself recently")
+	}
+}
+
+func TestHiddenPresenceIsNotBroadcast(t *testing.T) {
+	sqlitetest.Open(t)
+	hub, _ := newTestHub(t)
+	alice := sqlitetest.CreateUser(t, "alice")
+	bob := sqlitetest.CreateUser(t, "bob")
+	if _, err := sqlite.DB.Exec("INSERT INTO followers (follower_id, followed_id, status) VALUES (?, ?, 'accepted')", bob, alice); err != nil {
+		t.Fatal(err)
+	}
+	follower := newTestClient(hub, bob, "session", sendBufferSize)
+	hub.register <- follower.Client
+
+	// nextMessage returns the type of the next message bob receives. A marker is
+	// sent after it, which comes first when nothing else was sent.
+	nextMessage := func() string {
+		t.Helper()
+		SendMessageToUsers(hub, []int{bob}, []byte(`{"type":"marker"}`))
+		var message receivedMessage
+		if err := json.Unmarshal(<-follower.send, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Type != "marker" {
+			<-follower.send
+		}
+		return message.Type
+	}
+
+	steps := []struct {
+		name   string
+		action string // "presence" when alice connects or disconnects, otherwise the setting she saves
+		want   string
+	}{
+		{name: "connecting while shown", action: "presence", want: MessageTypePresence},
+		{name: "hiding", action: "hide", want: MessageTypePresence},
+		{name: "hiding again", action: "hide", want: "marker"},
+		{name: "connecting while hidden", action: "presence", want: "marker"},
+		{name: "disconnecting while hidden", action: "presence", want: "marker"},
+		{name: "showing", action: "show", want: MessageTypePresence},
+	}
+	for _, step := range steps {
+		if step.action == "presence" {
+			userPresenceChanged(hub, alice)
+		} else {
+			// As the settings handler does
+			changed, err := query.SetShowPresence(alice, step.action == "show")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed {
+				BroadcastPresence(hub, alice)
+			}
+		}
+		if got := nextMessage(); got != step.want {
+			t.Errorf("%s: bob received %s, want %s", step.name, got, step.want)
+		}
+	}
+}
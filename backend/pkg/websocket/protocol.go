@@ -0,0 +1,363 @@
+package websocket
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// Message types a client may send over the socket. Every inbound frame uses the
+// same {type, payload} envelope as the messages the server pushes.
+const (
+	MessageTypeChat        = "chat"
+	MessageTypeTypingStart = "typing_start"
+	MessageTypeTypingStop  = "typing_stop"
+	MessageTypeMarkRead    = "mark_read"
+	MessageTypePing        = "ping"
+	MessageTypeResume      = "resume"
+	MessageTypePong        = "pong"
+	MessageTypeResumed     = "resumed"
+	MessageTypeResync      = "resync"
+	MessageTypeError       = "error"
+)
+
+// Most events replayed on resume. A client that missed more, or whose gap was
+// already pruned, is told to resync and refetch its state over HTTP instead.
+const maxReplayEvents = sendBufferSize / 2
+
+// Error codes sent back to the client in an error frame
+const (
+	ErrorInvalidMessage = "invalid_message"
+	ErrorUnknownType    = "unknown_type"
+	ErrorForbidden      = "forbidden"
+	ErrorSlowMode       = "slow_mode"
+	ErrorInternal       = "internal_error"
+)
+
+// ErrorPayload is the payload of an error frame sent to a single connection.
+type ErrorPayload struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	RequestType string `json:"requestType,omitempty"` // Type of the inbound message that failed
+}
+
+// ChatCommand is the payload of a "chat" message sent by a client.
+type ChatCommand struct {
+	ReceiverID int    `json:"receiverId"`
+	GroupID    int    `json:"groupId"`
+	Content    string `json:"content"`
+	ReplyToID  int    `json:"replyToId"` // Optional earlier message of the conversation to quote
+}
+
+// TypingCommand is the payload of a "typing_start" or "typing_stop" message. The
+// same struct is relayed to the other participants with UserID set to the sender.
+type TypingCommand struct {
+	UserID     int `json:"userId"`
+	ReceiverID int `json:"receiverId"`
+	GroupID    int `json:"groupId"`
+}
+
+// MarkReadCommand is the payload of a "mark_read" message. Exactly one of
+// UserID (private chat partner) or GroupID must be set. MessageID is the last
+// message read, 0 marks the whole conversation as read.
+type MarkReadCommand struct {
+	UserID    int `json:"userId"`
+	GroupID   int `json:"groupId"`
+	MessageID int `json:"messageId"`
+}
+
+// ResumeCommand is the payload of a "resume" message. LastSeq is the highest
+// sequence number the client has applied, 0 for a client with fresh state.
+// Clients ignore any event whose seq they have already seen.
+type ResumeCommand struct {
+	LastSeq int64 `json:"lastSeq"`
+}
+
+// ResumePayload is sent after the replay ("resumed") or instead of it ("resync").
+// Seq is the sequence the client is now caught up to.
+type ResumePayload struct {
+	Seq int64 `json:"seq"`
+}
+
+// handleMessage decodes an inbound frame and dispatches it by type.
+func (c *Client) handleMessage(data []byte) {
+	var payload json.RawMessage
+	message := Message{Payload: &payload}
+	if err := json.Unmarshal(data, &message); err != nil {
+		c.sendError(ErrorInvalidMessage, "Message is not a valid {type, payload} object", "")
+		return
+	}
+
+	switch message.Type {
+	case MessageTypeChat:
+		c.handleChat(payload)
+	case MessageTypeTypingStart:
+		c.handleTypingStart(payload)
+	case MessageTypeTypingStop:
+		c.handleTypingStop(payload)
+	case MessageTypeMarkRead:
+		c.handleMarkRead(payload)
+	case MessageTypeResume:
+		c.handleResume(payload)
+	case MessageTypePing:
+		c.sendToSelf(Message{Type: MessageTypePong})
+	default:
+		c.sendError(ErrorUnknownType, "Unknown message type", message.Type)
+	}
+}
+
+func (c *Client) handleChat(payload json.RawMessage) {
+	var command ChatCommand
+	if err := json.Unmarshal(payload, &command); err != nil {
+		c.sendError(ErrorInvalidMessage, "Invalid chat payload", MessageTypeChat)
+		return
+	}
+	command.Content = strings.TrimSpace(command.Content)
+	if command.Content == "" || len(command.Content) > MaxMessageLength {
+		c.sendError(ErrorInvalidMessage, "Message content is empty or too long", MessageTypeChat)
+		return
+	}
+	if (command.ReceiverID == 0) == (command.GroupID == 0) {
+		c.sendError(ErrorInvalidMessage, "Exactly one of receiverId or groupId is required", MessageTypeChat)
+		return
+	}
+
+	// Accounts that have not confirmed their email address cannot message anyone
+	verified, err := query.IsEmailVerified(c.userID)
+	if err != nil {
+		c.sendError(ErrorInternal, "Failed to check chat permissions", MessageTypeChat)
+		return
+	}
+	if !verified {
+		c.sendError(ErrorForbidden, "Verify your email address first", MessageTypeChat)
+		return
+	}
+
+	allowChat, err := query.CanSendChatMessage(c.userID, command.ReceiverID, command.GroupID)
+	if err == nil && !allowChat && command.GroupID == 0 {
+		// Without a follow relationship the message goes to the receiver's message requests
+		allowChat, err = query.StartMessageRequest(c.userID, command.ReceiverID)
+	}
+	if err != nil {
+		c.sendError(ErrorInternal, "Failed to check chat permissions", MessageTypeChat)
+		return
+	}
+	if !allowChat {
+		c.sendError(ErrorForbidden, "You are not allowed to message this conversation", MessageTypeChat)
+		return
+	}
+	if command.GroupID != 0 {
+		wait, err := query.GetSlowModeWait(c.userID, command.GroupID, time.Now())
+		if err != nil {
+			c.sendError(ErrorInternal, "Failed to check group chat settings", MessageTypeChat)
+			return
+		}
+		if wait > 0 {
+			c.sendError(ErrorSlowMode, fmt.Sprintf("Slow mode is on, wait %d seconds before sending another message", int(math.Ceil(wait.Seconds()))), MessageTypeChat)
+			return
+		}
+	}
+
+	chatMessage := models.ChatMessage{
+		SenderID:   c.userID,
+		ReceiverID: command.ReceiverID,
+		GroupID:    command.GroupID,
+		Content:    command.Content,
+		CreatedAt:  time.Now(),
+		ReplyToID:  command.ReplyToID,
+	}
+	if chatMessage.ReplyToID != 0 {
+		validReply, err := query.IsValidReply(chatMessage.ReplyToID, chatMessage)
+		if err != nil {
+			c.sendError(ErrorInternal, "Failed to check the replied message", MessageTypeChat)
+			return
+		}
+		if !validReply {
+			c.sendError(ErrorInvalidMessage, "Can only reply to a message of the same conversation", MessageTypeChat)
+			return
+		}
+	}
+
+	chatMessage, err = query.CreateChatMessage(chatMessage)
+	if err != nil {
+		c.sendError(ErrorInternal, "Failed to add message", MessageTypeChat)
+		return
+	}
+
+	// Sending the message ends the sender's typing indicator in that conversation
+	c.hub.typing <- typingEvent{
+		key: typingKey{userID: c.userID, receiverID: command.ReceiverID, groupID: command.GroupID},
+	}
+	if chatMessage.GroupID == 0 {
+		SendChatToUsers(c.hub, chatMessage)
+	} else {
+		SendGroupChatToUsers(c.hub, chatMessage)
+	}
+}
+
+func (c *Client) handleTypingStart(payload json.RawMessage) {
+	var command TypingCommand
+	if err := json.Unmarshal(payload, &command); err != nil || (command.ReceiverID == 0) == (command.GroupID == 0) {
+		c.sendError(ErrorInvalidMessage, "Invalid typing payload", MessageTypeTypingStart)
+		return
+	}
+
+	allowChat, err := query.CanSendChatMessage(c.userID, command.ReceiverID, command.GroupID)
+	if err != nil {
+		c.sendError(ErrorInternal, "Failed to check chat permissions", MessageTypeTypingStart)
+		return
+	}
+	if !allowChat {
+		c.sendError(ErrorForbidden, "You are not allowed to message this conversation", MessageTypeTypingStart)
+		return
+	}
+
+	recipients := []int{command.ReceiverID}
+	if command.GroupID != 0 {
+		group, err := query.GetGroupData(command.GroupID)
+		if err != nil {
+			c.sendError(ErrorInternal, "Failed to get group members", MessageTypeTypingStart)
+			return
+		}
+		recipients = nil
+		for _, member := range group.Members {
+			if member.ID != c.userID {
+				recipients = append(recipients, member.ID)
+			}
+		}
+	}
+
+	c.hub.typing <- typingEvent{
+		key:        typingKey{userID: c.userID, receiverID: command.ReceiverID, groupID: command.GroupID},
+		recipients: recipients,
+		typing:     true,
+	}
+}
+
+// handleTypingStop needs no permission check, the hub only stops an indicator
+// this user previously started.
+func (c *Client) handleTypingStop(payload json.RawMessage) {
+	var command TypingCommand
+	if err := json.Unmarshal(payload, &command); err != nil || (command.ReceiverID == 0) == (command.GroupID == 0) {
+		c.sendError(ErrorInvalidMessage, "Invalid typing payload", MessageTypeTypingStop)
+		return
+	}
+	c.hub.typing <- typingEvent{
+		key: typingKey{userID: c.userID, receiverID: command.ReceiverID, groupID: command.GroupID},
+	}
+}
+
+func (c *Client) handleMarkRead(payload json.RawMessage) {
+	var command MarkReadCommand
+	if err := json.Unmarshal(payload, &command); err != nil || (command.UserID == 0) == (command.GroupID == 0) || command.MessageID < 0 {
+		c.sendError(ErrorInvalidMessage, "Exactly one of userId or groupId is required", MessageTypeMarkRead)
+		return
+	}
+
+	if command.GroupID != 0 {
+		status, err := query.GetMemberStatus(c.userID, command.GroupID)
+		if err != nil {
+			c.sendError(ErrorInternal, "Failed to check user group member status", MessageTypeMarkRead)
+			return
+		}
+		if status != "accepted" {
+			c.sendError(ErrorForbidden, "You are not part of the group", MessageTypeMarkRead)
+			return
+		}
+	}
+
+	if err := MarkChatRead(c.hub, c.userID, command.UserID, command.GroupID, command.MessageID); err != nil {
+		c.sendError(ErrorInternal, "Failed to mark chat as read", MessageTypeMarkRead)
+	}
+}
+
+func (c *Client) handleResume(payload json.RawMessage) {
+	var command ResumeCommand
+	if err := json.Unmarshal(payload, &command); err != nil || command.LastSeq < 0 {
+		c.sendError(ErrorInvalidMessage, "Invalid resume payload", MessageTypeResume)
+		return
+	}
+	c.resumeFrom(command.LastSeq, command.LastSeq == 0)
+}
+
+// resumeFrom replays the events stored after lastSeq to this connection, in order
+// and ahead of any live event, or tells it to resync when the gap cannot be filled.
+// A fresh client has no state yet and only needs to learn the current sequence.
+func (c *Client) resumeFrom(lastSeq int64, fresh bool) {
+	command := ResumeCommand{LastSeq: lastSeq}
+
+	// From here on the hub holds live events for this connection until the replay is queued
+	c.hub.resume <- c
+	batch := replayBatch{client: c}
+
+	minSeq, maxSeq, err := query.GetUserEventSeqRange(c.userID)
+	if err != nil {
+		minSeq, maxSeq = 0, 0
+		command.LastSeq = -1 // Force a resync, the gap cannot be checked
+	}
+
+	doneType := MessageTypeResumed
+	switch {
+	case command.LastSeq == maxSeq || (fresh && command.LastSeq == 0):
+		// Nothing missed, or a fresh client that only needs the current position
+	case command.LastSeq < 0 || command.LastSeq > maxSeq || command.LastSeq+1 < minSeq || maxSeq-command.LastSeq > maxReplayEvents:
+		doneType = MessageTypeResync
+	default:
+		events, err := query.GetUserEventsAfter(c.userID, command.LastSeq, maxReplayEvents)
+		if err != nil {
+			doneType = MessageTypeResync
+			break
+		}
+		for _, event := range events {
+			message, err := json.Marshal(Message{
+				Type:    event.Type,
+				Payload: json.RawMessage(event.Payload),
+				Seq:     event.Seq,
+			})
+			if err != nil {
+				log.Printf("Error marshalling replayed event: %v", err)
+				continue
+			}
+			batch.messages = append(batch.messages, message)
+			batch.lastSeq = event.Seq
+		}
+	}
+	if batch.lastSeq < maxSeq {
+		batch.lastSeq = maxSeq
+	}
+
+	done, err := json.Marshal(Message{Type: doneType, Payload: ResumePayload{Seq: batch.lastSeq}})
+	if err != nil {
+		log.Printf("Error marshalling %s message: %v", doneType, err)
+	}
+	batch.done = done
+	c.hub.replayed <- batch
+}
+
+// sendError replies to this connection only with a structured error frame.
+func (c *Client) sendError(code string, message string, requestType string) {
+	c.sendToSelf(Message{
+		Type: MessageTypeError,
+		Payload: ErrorPayload{
+			Code:        code,
+			Message:     message,
+			RequestType: requestType,
+		},
+	})
+}
+
+// sendToSelf queues a message for this connection through the hub, which
+// ignores it if the connection has already been removed.
+func (c *Client) sendToSelf(message Message) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshalling reply: %v", err)
+		return
+	}
+	c.hub.reply <- clientMessage{client: c, payload: payload}
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/db/sqlite"
+	"backend/pkg/db/sqlite/sqlitetest"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// receivedMessage is a message as the client decodes it
+type receivedMessage struct {
+	Type    string          `json:"type"`
+	Seq     int64           `json:"seq"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// readUntilResumed returns the messages queued for the connection up to and
+// including the "resumed" or "resync" one.
+func readUntilResumed(t *testing.T, client *testClient) []receivedMessage {
+	t.Helper()
+	var messages []receivedMessage
+	for {
+		data, ok := <-client.send
+		if !ok {
+			t.Fatal("connection closed during the replay")
+		}
+		var message receivedMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		messages = append(messages, message)
+		if message.Type == MessageTypeResumed || message.Type == MessageTypeResync {
+			return messages
+		}
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   int64 // Events stored for the user, seq 1 to events
+		pruned   int64 // Events up to this seq are gone
+		lastSeq  int64
+		fresh    bool // The client has no state yet
+		done     string
+		replayed []int64 // First and last seq replayed, nil for none
+	}{
+		{name: "up to date", events: 10, lastSeq: 10, done: MessageTypeResumed},
+		{name: "fresh client", events: 10, lastSeq: 0, fresh: true, done: MessageTypeResumed},
+		{name: "no events yet", events: 0, lastSeq: 0, fresh: true, done: MessageTypeResumed},
+		{name: "caught up before the first event", events: 3, lastSeq: 0, done: MessageTypeResumed, replayed: []int64{1, 3}},
+		{name: "missed a few", events: 10, lastSeq: 4, done: MessageTypeResumed, replayed: []int64{5, 10}},
+		{name: "missed the most replayed", events: maxReplayEvents + 5, lastSeq: 5, done: MessageTypeResumed, replayed: []int64{6, maxReplayEvents + 5}},
+		{name: "missed too many", events: maxReplayEvents + 5, lastSeq: 4, done: MessageTypeResync},
+		{name: "gap already pruned", events: 20, pruned: 10, lastSeq: 8, done: MessageTypeResync},
+		{name: "resumes right after pruned events", events: 20, pruned: 10, lastSeq: 10, done: MessageTypeResumed, replayed: []int64{11, 20}},
+		{name: "ahead of the server", events: 10, lastSeq: 12, done: MessageTypeResync},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlitetest.Open(t)
+			hub, _ := newTestHub(t)
+			userID := sqlitetest.CreateUser(t, "resumer")
+
+			for i := int64(1); i <= test.events; i++ {
+				seq, err := query.CreateUserEvent(userID, "notification", fmt.Sprintf(`{"n":%d}`, i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if seq != i {
+					t.Fatalf("event %d stored with seq %d", i, seq)
+				}
+			}
+			if _, err := sqlite.DB.Exec("DELETE FROM user_events WHERE seq <= ?", test.pruned); err != nil {
+				t.Fatal(err)
+			}
+
+			client := newTestClient(hub, userID, "session", sendBufferSize)
+			hub.register <- client.Client
+			client.resumeFrom(test.lastSeq, test.fresh)
+			messages := readUntilResumed(t, client)
+
+			done := messages[len(messages)-1]
+			if done.Type != test.done {
+				t.Errorf("got %s, want %s", done.Type, test.done)
+			}
+			var position ResumePayload
+			if err := json.Unmarshal(done.Payload, &position); err != nil {
+				t.Fatal(err)
+			}
+			if position.Seq != test.events {
+				t.Errorf("%s at seq %d, want %d", done.Type, position.Seq, test.events)
+			}
+
+			replayed := messages[:len(messages)-1]
+			if test.replayed == nil {
+				if len(replayed) != 0 {
+					t.Fatalf("replayed %d events, want none", len(replayed))
+				}
+				return
+			}
+			first, last := test.replayed[0], test.replayed[1]
+			if int64(len(replayed)) != last-first+1 {
+				t.Fatalf("replayed %d events, want %d", len(replayed), last-first+1)
+			}
+			for i, message := range replayed {
+				want := first + int64(i)
+				if message.Seq != want || string(message.Payload) != fmt.Sprintf(`{"n":%d}`, want) {
+					t.Fatalf("replayed event %d is seq %d %s, want seq %d", i, message.Seq, message.Payload, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisBroker is a Broker backed by Redis PUBLISH/SUBSCRIBE. It speaks the
+// Redis protocol (RESP) directly, so any compatible server works, including a
+// local redis-server for development.
+type RedisBroker struct {
+	addr    string
+	channel string
+
+	pubMu      sync.Mutex
+	pubConn    net.Conn // nil while it is being dialed
+	pubRead    *bufio.Reader
+	pubDialing bool
+
+	subMu   sync.Mutex
+	subConn net.Conn
+	closed  chan struct{}
+}
+
+const (
+	redisDialTimeout  = 5 * time.Second
+	redisRetryBackoff = time.Second
+
+	// Time allowed to publish a message. Publishing runs on the goroutine of the
+	// request or event being delivered, so a stuck server must not hold it up.
+	redisPublishTimeout = 500 * time.Millisecond
+)
+
+var errRedisNotConnected = errors.New("redis publisher is not connected")
+
+func NewRedisBroker(addr string, channel string) *RedisBroker {
+	b := &RedisBroker{
+		addr:    addr,
+		channel: channel,
+		closed:  make(chan struct{}),
+	}
+	b.pubMu.Lock()
+	b.dialPublisher()
+	b.pubMu.Unlock()
+	return b
+}
+
+// Publish sends the message to every instance subscribed to the channel. It fails
+// at once while the connection is being dialed, the caller then delivers locally.
+func (b *RedisBroker) Publish(message []byte) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	if b.pubConn == nil {
+		b.dialPublisher()
+		return errRedisNotConnected
+	}
+
+	b.pubConn.SetDeadline(time.Now().Add(redisPublishTimeout))
+	err := writeRedisCommand(b.pubConn, []byte("PUBLISH"), []byte(b.channel), message)
+	if err == nil {
+		_, err = readRedisReply(b.pubRead)
+	}
+	if err != nil {
+		// Drop the connection, a fresh one is dialed in the background
+		b.pubConn.Close()
+		b.pubConn = nil
+		b.dialPublisher()
+		return err
+	}
+	return nil
+}
+
+// dialPublisher connects the publishing connection in the background, retrying
+// until it succeeds or the broker is closed. It must be called with pubMu held.
+func (b *RedisBroker) dialPublisher() {
+	if b.pubDialing {
+		return
+	}
+	b.pubDialing = true
+
+	go func() {
+		for {
+			conn, err := net.DialTimeout("tcp", b.addr, redisDialTimeout)
+			if err == nil {
+				b.pubMu.Lock()
+				defer b.pubMu.Unlock()
+				b.pubDialing = false
+				select {
+				case <-b.closed:
+					conn.Close()
+				default:
+					b.pubConn = conn
+					b.pubRead = bufio.NewReader(conn)
+					log.Printf("Redis publisher connected to %s", b.addr)
+				}
+				return
+			}
+
+			log.Printf("Error connecting Redis publisher to %s, retrying in %v: %v", b.addr, redisRetryBackoff, err)
+			select {
+			case <-b.closed:
+				return
+			case <-time.After(redisRetryBackoff):
+			}
+		}
+	}()
+}
+
+// Subscribe starts a background loop delivering channel messages to handler.
+// The loop reconnects until the broker is closed. Redis does not keep messages
+// for absent subscribers, so whatever is published until the subscription is
+// restored is lost. subscribed is called every time Redis confirms the subscription.
+func (b *RedisBroker) Subscribe(handler func(message []byte), subscribed func()) error {
+	go func() {
+		connected, lost := false, false
+		for {
+			err := b.listen(handler, func() {
+				if lost {
+					log.Printf("Redis subscription to %s restored", b.addr)
+				}
+				connected, lost = true, false
+				subscribed()
+			})
+			select {
+			case <-b.closed:
+				return
+			default:
+			}
+			if connected && !lost {
+				log.Printf("Redis subscription to %s lost, messages published until it is restored are missed: %v", b.addr, err)
+			} else {
+				log.Printf("Redis subscription to %s failed, retrying in %v: %v", b.addr, redisRetryBackoff, err)
+			}
+			lost = connected
+			time.Sleep(redisRetryBackoff)
+		}
+	}()
+	return nil
+}
+
+// listen subscribes on a new connection and delivers its messages until it fails.
+// subscribed is called when Redis confirms the subscription.
+func (b *RedisBroker) listen(handler func(message []byte), subscribed func()) error {
+	conn, err := net.DialTimeout("tcp", b.addr, redisDialTimeout)
+	if err != nil {
+		return err
+	}
+	b.subMu.Lock()
+	b.subConn = conn
+	b.subMu.Unlock()
+	defer conn.Close()
+
+	if err := writeRedisCommand(conn, []byte("SUBSCRIBE"), []byte(b.channel)); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return err
+		}
+		// Pushed messages are ["message", channel, payload], the rest are confirmations
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		kind, _ := parts[0].([]byte)
+		payload, _ := parts[2].([]byte)
+		switch {
+		case string(kind) == "message" && payload != nil:
+			handler(payload)
+		case string(kind) == "subscribe":
+			subscribed()
+		}
+	}
+}
+
+func (b *RedisBroker) Close() error {
+	select {
+	case <-b.closed:
+		return nil
+	default:
+		close(b.closed)
+	}
+
+	b.subMu.Lock()
+	if b.subConn != nil {
+		b.subConn.Close()
+	}
+	b.subMu.Unlock()
+
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+	if b.pubConn != nil {
+		b.pubConn.Close()
+		b.pubConn = nil
+	}
+	return nil
+}
+
+// writeRedisCommand encodes a command as a RESP array of bulk strings.
+func writeRedisCommand(w io.Writer, args ...[]byte) error {
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(arg))...)
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readRedisReply decodes one RESP value: simple strings and bulk strings as
+// []byte, integers as int64, arrays as []interface{} and errors as error.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed redis reply")
+	}
+	body := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", body)
+	case ':':
+		return strconv.ParseInt(string(body), 10, 64)
+	case '$':
+		size, err := strconv.Atoi(string(body))
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(string(body))
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, count)
+		for i := range values {
+			if values[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type %q", line[0])
+	}
+}
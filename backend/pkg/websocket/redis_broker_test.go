@@ -0,0 +1,307 @@
+package websocket
+
+import (
+	"backend/pkg/db/sqlite/sqlitetest"
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server that knows just
+// SUBSCRIBE and PUBLISH.
+type fakeRedis struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	subscribers map[net.Conn]*sync.Mutex // Write lock of each subscribed connection
+	conns       map[net.Conn]bool
+	stalled     bool // PUBLISH commands are left unanswered
+	published   [][]byte
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRedis{
+		listener:    listener,
+		subscribers: make(map[net.Conn]*sync.Mutex),
+		conns:       make(map[net.Conn]bool),
+	}
+	t.Cleanup(server.close)
+	go server.serve()
+	return server
+}
+
+func (s *fakeRedis) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, conn)
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		args, _ := reply.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		command, _ := args[0].([]byte)
+		switch string(command) {
+		case "SUBSCRIBE":
+			channel, _ := args[1].([]byte)
+			writeLock := &sync.Mutex{}
+			writeLock.Lock()
+			s.mu.Lock()
+			s.subscribers[conn] = writeLock
+			s.mu.Unlock()
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(channel), channel)
+			writeLock.Unlock()
+		case "PUBLISH":
+			channel, _ := args[1].([]byte)
+			message, _ := args[2].([]byte)
+			s.mu.Lock()
+			if s.stalled {
+				s.mu.Unlock()
+				continue
+			}
+			s.published = append(s.published, message)
+			receivers := 0
+			for subscriber, writeLock := range s.subscribers {
+				writeLock.Lock()
+				writeRedisCommand(subscriber, []byte("message"), channel, message)
+				writeLock.Unlock()
+				receivers++
+			}
+			s.mu.Unlock()
+			fmt.Fprintf(conn, ":%d\r\n", receivers)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", command)
+		}
+	}
+}
+
+func (s *fakeRedis) subscriberCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers)
+}
+
+// hasPublished reports whether a published message contains text
+func (s *fakeRedis) hasPublished(text string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, message := range s.published {
+		if bytes.Contains(message, []byte(text)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeRedis) setStalled(stalled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stalled = stalled
+}
+
+// dropSubscribers closes the subscribed connections, as a restarting server would
+func (s *fakeRedis) dropSubscribers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.subscribers {
+		conn.Close()
+		delete(s.subscribers, conn)
+	}
+}
+
+// close stops the server and drops every connection
+func (s *fakeRedis) close() {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// publisherConnected reports whether the broker can publish right away
+func publisherConnected(broker *RedisBroker) bool {
+	broker.pubMu.Lock()
+	defer broker.pubMu.Unlock()
+	return broker.pubConn != nil
+}
+
+// newRedisTestHub starts a hub on its own connections to the fake server. Its
+// presence changes are only counted.
+func newRedisTestHub(t *testing.T, server *fakeRedis) (*Hub, *int64) {
+	t.Helper()
+	var presenceChanges int64
+	broker := NewRedisBroker(server.addr(), "test:hub")
+	hub := NewHubWithBroker(broker)
+	hub.presenceChanged = func(int) { atomic.AddInt64(&presenceChanges, 1) }
+	t.Cleanup(func() { hub.Close() })
+	go hub.Run()
+	eventually(t, "the publisher to connect", func() bool { return publisherConnected(broker) })
+	// The hub asks the others who is connected once it is subscribed
+	eventually(t, "the hub to subscribe", func() bool {
+		return server.hasPublished(`"instance":"` + hub.instance + `","reply":true`)
+	})
+	return hub, &presenceChanges
+}
+
+func TestRedisBrokerDeliversAcrossInstances(t *testing.T) {
+	server := newFakeRedis(t)
+	first, _ := newRedisTestHub(t, server)
+	second, _ := newRedisTestHub(t, server)
+	eventually(t, "both hubs to subscribe", func() bool { return server.subscriberCount() == 2 })
+
+	alice := newTestClient(first, 1, "alice-session", sendBufferSize)
+	bob := newTestClient(second, 2, "bob-session", sendBufferSize)
+	first.register <- alice.Client
+	second.register <- bob.Client
+
+	tests := []struct {
+		name    string
+		from    *Hub
+		userIDs []int
+		payload string
+		want    map[*testClient]bool // Connections that must receive the payload
+	}{
+		{name: "to the other instance", from: first, userIDs: []int{2}, payload: `{"n":1}`, want: map[*testClient]bool{bob: true}},
+		{name: "back the other way", from: second, userIDs: []int{1}, payload: `{"n":2}`, want: map[*testClient]bool{alice: true}},
+		{name: "to both instances", from: first, userIDs: []int{1, 2}, payload: `{"n":3}`, want: map[*testClient]bool{alice: true, bob: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SendMessageToUsers(test.from, test.userIDs, []byte(test.payload))
+			for _, client := range []*testClient{alice, bob} {
+				if !test.want[client] {
+					continue
+				}
+				payload := <-client.send
+				if string(payload) != test.payload {
+					t.Errorf("user %d got %s, want %s", client.userID, payload, test.payload)
+				}
+			}
+		})
+	}
+	for _, client := range []*testClient{alice, bob} {
+		if len(client.send) != 0 {
+			t.Errorf("user %d got %d unexpected messages", client.userID, len(client.send))
+		}
+	}
+
+	// A logout on one instance closes the session's connections on the other
+	go bob.drain()
+	DisconnectSession(first, "bob-session")
+	<-bob.closed
+	if IsUserOnline(second, 2) {
+		t.Error("user 2 still online after their session logged out")
+	}
+	if !IsUserOnline(first, 1) {
+		t.Error("user 1 went offline after another user's logout")
+	}
+}
+
+func TestRedisBrokerReplaysMissedEventsAfterReconnect(t *testing.T) {
+	sqlitetest.Open(t)
+	userID := sqlitetest.CreateUser(t, "reconnecting")
+
+	server := newFakeRedis(t)
+	first, _ := newRedisTestHub(t, server)
+	second, _ := newRedisTestHub(t, server)
+	eventually(t, "both hubs to subscribe", func() bool { return server.subscriberCount() == 2 })
+
+	client := newTestClient(second, userID, "session", sendBufferSize)
+	second.register <- client.Client
+	client.resumeFrom(0, true)
+	readUntilResumed(t, client)
+
+	// Published while nobody is subscribed, the live message is lost
+	server.dropSubscribers()
+	SendEventToUsers(first, []int{userID}, "notification", map[string]int{"n": 1})
+
+	messages := readUntilResumed(t, client)
+	if len(messages) != 2 || messages[0].Seq != 1 || string(messages[0].Payload) != `{"n":1}` {
+		t.Fatalf("got %+v after the reconnect, want the missed event then resumed", messages)
+	}
+	if messages[1].Type != MessageTypeResumed {
+		t.Errorf("got %s, want %s", messages[1].Type, MessageTypeResumed)
+	}
+}
+
+func TestRedisBrokerPublishFailsFast(t *testing.T) {
+	server := newFakeRedis(t)
+	broker := NewRedisBroker(server.addr(), "test:hub")
+	t.Cleanup(func() { broker.Close() })
+	eventually(t, "the publisher to connect", func() bool { return publisherConnected(broker) })
+
+	publish := func() (time.Duration, error) {
+		start := time.Now()
+		err := broker.Publish([]byte("message"))
+		return time.Since(start), err
+	}
+	if _, err := publish(); err != nil {
+		t.Fatalf("publishing to a working server: %v", err)
+	}
+
+	server.setStalled(true)
+	elapsed, err := publish()
+	if err == nil {
+		t.Fatal("publishing to a stalled server succeeded")
+	}
+	if elapsed > 2*redisPublishTimeout {
+		t.Errorf("publishing to a stalled server took %v, want at most %v", elapsed, 2*redisPublishTimeout)
+	}
+
+	// The background dial restores publishing
+	server.setStalled(false)
+	eventually(t, "publishing to work again", func() bool {
+		_, err := publish()
+		return err == nil
+	})
+
+	// Without a server every publish fails at once while the dial is retried
+	server.close()
+	publish()
+	for i := 0; i < 3; i++ {
+		elapsed, err := publish()
+		if err != errRedisNotConnected {
+			t.Fatalf("publishing without a server returned %v, want %v", err, errRedisNotConnected)
+		}
+		if elapsed > redisPublishTimeout/10 {
+			t.Errorf("publishing without a server took %v", elapsed)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeSSE streams the same events as the WebSocket endpoint using Server-Sent
+// Events, for clients behind proxies that block WebSocket upgrades. The
+// subscriber is registered in the hub as a regular client without a socket, so
+// every Send*ToUser function reaches it. The stream is one-way, clients keep
+// using the HTTP endpoints to send messages.
+func ServeSSE(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, sessionID, ok := authenticateSession(w, r)
+	if !ok {
+		return
+	}
+
+	// Browsers send Last-Event-ID when EventSource reconnects, lastSeq lets a
+	// client resume a stream it opened itself
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastSeq")
+	}
+	var lastSeq int64
+	if lastEventID != "" {
+		var err error
+		lastSeq, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastSeq < 0 {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop reverse proxies from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		log.Printf("Streaming not supported: %v", err)
+		return
+	}
+
+	client := &Client{
+		hub:       hub,
+		send:      make(chan []byte, sendBufferSize),
+		userID:    user.ID,
+		sessionID: sessionID,
+	}
+	hub.register <- client
+	defer func() {
+		hub.unregister <- client
+	}()
+
+	// Replay runs alongside the stream loop, which drains what it queues
+	go client.resumeFrom(lastSeq, lastSeq == 0)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				// The hub closed the channel, e.g. the session logged out or the client was evicted
+				return
+			}
+			controller.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := writeSSEEvent(w, message); err != nil {
+				return
+			}
+			// Add queued messages before flushing
+			n := len(client.send)
+			for i := 0; i < n; i++ {
+				if err := writeSSEEvent(w, <-client.send); err != nil {
+					return
+				}
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		case <-ticker.C:
+			// Comment lines keep idle proxies from closing the stream
+			controller.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes one message as an SSE event. The data is the same
+// {type, payload, seq} envelope the WebSocket sends, and the seq becomes the
+// event ID so the browser resumes from it after a reconnect.
+func writeSSEEvent(w http.ResponseWriter, message []byte) error {
+	var envelope struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := json.Unmarshal(message, &envelope); err == nil && envelope.Seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", envelope.Seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", message)
+	return err
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// A typing indicator lasts typingTimeout after the last typing_start the
+// client sent, so clients repeat typing_start while the user keeps typing.
+// Indicators are only held in memory and never stored with the messages.
+const (
+	typingTimeout     = 6 * time.Second
+	typingSweepPeriod = time.Second
+)
+
+// typingKey identifies one user typing in one conversation.
+type typingKey struct {
+	userID     int
+	receiverID int
+	groupID    int
+}
+
+// typingState is an active indicator and the users it was relayed to.
+type typingState struct {
+	recipients []int
+	expires    time.Time
+}
+
+// typingEvent starts or stops the indicator of key.
+type typingEvent struct {
+	key        typingKey
+	recipients []int
+	typing     bool
+}
+
+// setTyping records a typing_start or typing_stop. Other participants are only
+// told when the indicator changes, not on every repeated typing_start.
+func (h *Hub) setTyping(event typingEvent) {
+	state, active := h.typists[event.key]
+	if !event.typing {
+		if active {
+			delete(h.typists, event.key)
+			relayTyping(h, event.key, state.recipients, MessageTypeTypingStop)
+		}
+		return
+	}
+
+	h.typists[event.key] = typingState{
+		recipients: event.recipients,
+		expires:    time.Now().Add(typingTimeout),
+	}
+	if !active {
+		relayTyping(h, event.key, event.recipients, MessageTypeTypingStart)
+	}
+}
+
+// expireTyping stops the indicators that were not refreshed in time, such as
+// those of a client that crashed or lost its connection mid-sentence.
+func (h *Hub) expireTyping(now time.Time) {
+	for key, state := range h.typists {
+		if now.After(state.expires) {
+			delete(h.typists, key)
+			relayTyping(h, key, state.recipients, MessageTypeTypingStop)
+		}
+	}
+}
+
+// stopUserTyping stops every indicator of a user whose last connection closed.
+func (h *Hub) stopUserTyping(userID int) {
+	for key, state := range h.typists {
+		if key.userID == userID {
+			delete(h.typists, key)
+			relayTyping(h, key, state.recipients, MessageTypeTypingStop)
+		}
+	}
+}
+
+// relayTyping queues an unsequenced typing_start or typing_stop for the
+// recipients. It is called from the Run loop, which cannot publish itself as the
+// delivery comes back through it.
+func relayTyping(hub *Hub, key typingKey, recipients []int, messageType string) {
+	payload, err := json.Marshal(Message{
+		Type: messageType,
+		Payload: TypingCommand{
+			UserID:     key.userID,
+			ReceiverID: key.receiverID,
+			GroupID:    key.groupID,
+		},
+	})
+	if err != nil {
+		log.Printf("Error marshalling %s event: %v", messageType, err)
+		return
+	}
+	select {
+	case hub.typingOut <- brokerMessage{Kind: brokerKindDirect, UserIDs: recipients, Payload: payload}:
+	default:
+		log.Printf("Typing queue full, dropping %s event", messageType)
+	}
+}
+
+// publishTyping sends the queued typing indicators one after the other, so a
+// typing_stop never overtakes the typing_start before it. It runs in its own
+// goroutine for as long as the hub.
+func (h *Hub) publishTyping() {
+	for message := range h.typingOut {
+		h.publish(message)
+	}
+}
@@ -0,0 +1,34 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTypingEventsStayInOrder(t *testing.T) {
+	hub, _ := newTestHub(t)
+	recipient := newTestClient(hub, 2, "session", sendBufferSize)
+	hub.register <- recipient.Client
+
+	// Starting and stopping in quick succession, fewer times than the queue holds
+	const pairs = sendBufferSize / 4
+	key := typingKey{userID: 1, receiverID: 2}
+	for i := 0; i < pairs; i++ {
+		hub.typing <- typingEvent{key: key, recipients: []int{2}, typing: true}
+		hub.typing <- typingEvent{key: key}
+	}
+
+	for i := 0; i < 2*pairs; i++ {
+		var message receivedMessage
+		if err := json.Unmarshal(<-recipient.send, &message); err != nil {
+			t.Fatal(err)
+		}
+		want := MessageTypeTypingStart
+		if i%2 == 1 {
+			want = MessageTypeTypingStop
+		}
+		if message.Type != want {
+			t.Fatalf("event %d is %s, want %s", i, message.Type, want)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package websocket
 
 import (
+	query "backend/pkg/db/queries"
+	"backend/pkg/models"
+	"backend/pkg/utilities"
+	"encoding/json"
 	"log"
 	"net/http"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -17,25 +22,63 @@ var upgrader = websocket.Upgrader{
 }
 
 type Client struct {
-	hub    *Hub
-	conn   *websocket.Conn
-	send   chan []byte
-	userID int
+	hub       *Hub
+	conn      *websocket.Conn
+	send      chan []byte
+	userID    int
+	sessionID string // Session used to authenticate the handshake
+
+	// Owned by the hub's Run loop: while a replay is in flight, live messages
+	// are held in backlog so the client receives everything in sequence order
+	replaying bool
+	backlog   []directMessage
+
+	// Also owned by the Run loop: the highest sequence queued for the client, known
+	// once its first replay is written, used to replay what the broker missed
+	caughtUp          bool
+	lastSeq           int64
+	resumeAfterReplay bool
 }
 
 // Message represents a generic message structure for WebSocket communication.
 type Message struct {
-	Type    string      `json:"type"`    // Type of the message (e.g., "notification")
-	Payload interface{} `json:"payload"` // The actual data being sent (can be any type)
+	Type    string      `json:"type"`          // Type of the message (e.g., "notification")
+	Payload interface{} `json:"payload"`       // The actual data being sent (can be any type)
+	Seq     int64       `json:"seq,omitempty"` // Per-user sequence number, set on events that can be replayed
 }
 
 const MaxMessageLength = 2000
 
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the peer
+	pongWait = 60 * time.Second
+
+	// Send pings to the peer with this period, must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// Largest inbound frame accepted, the content limit plus room for the {type, payload} envelope
+	maxFrameSize = MaxMessageLength + 512
+
+	// Number of outbound messages buffered per connection. A connection whose
+	// buffer is full is evicted by the hub instead of silently losing messages.
+	sendBufferSize = 256
+)
+
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
+	// Frames above the limit make ReadMessage fail and close the connection
+	c.conn.SetReadLimit(maxFrameSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
@@ -45,24 +88,23 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// Add message length validation
-		if len(message) > MaxMessageLength {
-			log.Printf("Message exceeds maximum length of %d bytes", MaxMessageLength)
-			continue
-		}
-
-		c.hub.broadcast <- message
+		// Dispatch the typed command instead of relaying the raw frame
+		c.handleMessage(message)
 	}
 }
 
 func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
 	}()
 	for {
 		select {
 		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
+				// The hub closed the channel
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
@@ -71,34 +113,71 @@ func (c *Client) writePump() {
 				return
 			}
 			w.Write(message)
+
+			// Add queued messages to the current frame, the client splits them on newlines
+			n := len(c.send)
+			for i := 0; i < n; i++ {
+				w.Write([]byte{'\n'})
+				w.Write(<-c.send)
+			}
+
 			if err := w.Close(); err != nil {
 				return
 			}
+		case <-ticker.C:
+			// A failed ping means the peer is gone, returning closes the socket and readPump unregisters
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// authenticateSession resolves the user of the request's session cookie. It writes
+// the error response itself and returns ok false when the request is rejected.
+func authenticateSession(w http.ResponseWriter, r *http.Request) (user *models.User, sessionID string, ok bool) {
+	cookie, err := r.Cookie("session_id")
 	if err != nil {
-		log.Println(err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+
+	// GetSessionUser only returns users whose session has not expired
+	user, err = query.GetSessionUser(cookie.Value)
+	if err != nil {
+		log.Printf("Error authenticating real-time connection: %v", err)
+		http.Error(w, "Error checking session", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+	query.RefreshSession(cookie.Value, utilities.ClientIP(r))
+	return user, cookie.Value, true
+}
+
+func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	// Authenticate the handshake with the session cookie before upgrading
+	user, sessionID, ok := authenticateSession(w, r)
+	if !ok {
 		return
 	}
 
-	// Extract userID from the query parameters
-	userIDStr := r.URL.Query().Get("userID")
-	userID, err := strconv.Atoi(userIDStr)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Invalid userID:", err)
+		log.Println(err)
 		return
 	}
 
-	// Create a new Client instance with the userID
+	// Create a new Client instance for the authenticated user
 	client := &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID, // Set the userID for the client
+		hub:       hub,
+		conn:      conn,
+		send:      make(chan []byte, sendBufferSize),
+		userID:    user.ID,
+		sessionID: sessionID,
 	}
 
 	// Register the client in the hub
@@ -110,16 +189,51 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 }
 
 func SendMessageToUser(hub *Hub, userID int, payload []byte) {
-	client, ok := hub.clients[userID]
-	if !ok {
-		log.Printf("User with ID %d not connected", userID)
+	// The hub owns the client map, hand the payload over to its Run loop
+	SendMessageToUsers(hub, []int{userID}, payload)
+}
+
+// eventLocks serializes storing and publishing the events of a user, so that this
+// instance publishes them in sequence order. Users share a lock when their IDs
+// fall on the same slot. Events stored by other instances may still arrive out
+// of order, clients resume to fill any gap they see.
+var eventLocks [64]sync.Mutex
+
+// SendEventToUsers stores the event for each user under their next sequence
+// number and pushes it to their open connections. Offline users receive it
+// when they reconnect and resume from their last seen sequence.
+func SendEventToUsers(hub *Hub, userIDs []int, eventType string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshalling %s event: %v", eventType, err)
 		return
 	}
-	// Send the message to the specific user's WebSocket connection
-	select {
-	case client.send <- payload: // Assuming client has a send channel
-		log.Printf("Payload sent to user %d", userID)
-	default:
-		log.Printf("User %d's WebSocket connection is not ready", userID)
+
+	for _, userID := range userIDs {
+		sendEventToUser(hub, userID, eventType, data)
+	}
+}
+
+// sendEventToUser stores and publishes one event as a single step per user
+func sendEventToUser(hub *Hub, userID int, eventType string, data []byte) {
+	lock := &eventLocks[uint(userID)%uint(len(eventLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	seq, err := query.CreateUserEvent(userID, eventType, string(data))
+	if err != nil {
+		log.Printf("Error storing %s event for user %d: %v", eventType, userID, err)
+		return
+	}
+
+	message, err := json.Marshal(Message{
+		Type:    eventType,
+		Payload: json.RawMessage(data),
+		Seq:     seq,
+	})
+	if err != nil {
+		log.Printf("Error marshalling %s event: %v", eventType, err)
+		return
 	}
+	hub.publish(brokerMessage{Kind: brokerKindDirect, UserIDs: []int{userID}, Payload: message, Seq: seq})
 }
@@ -0,0 +1,64 @@
+import { API_BASE_URL, getAuthHeaders } from '../config';
+
+// Reads the error message the backend sends as plain text, or as {error} JSON
+// when the request is throttled
+const errorMessage = async (response: Response, fallback: string) => {
+  const text = (await response.text()).trim();
+  try {
+    const body = JSON.parse(text);
+    if (typeof body?.error === 'string') {
+      return body.error;
+    }
+  } catch (error) {
+    // Plain text
+  }
+  return text || fallback;
+};
+
+export const requestPasswordReset = async (email: string) => {
+  const response = await fetch(`${API_BASE_URL}/api/password/forgot`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ email }),
+  });
+  if (!response.ok) {
+    throw new Error(await errorMessage(response, 'Failed to request password reset'));
+  }
+  return await response.json();
+};
+
+export const resetPassword = async (token: string, password: string) => {
+  const response = await fetch(`${API_BASE_URL}/api/password/reset`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ token, password }),
+  });
+  if (!response.ok) {
+    throw new Error(await errorMessage(response, 'Failed to reset password'));
+  }
+  return await response.json();
+};
+
+export const verifyEmail = async (token: string) => {
+  const response = await fetch(`${API_BASE_URL}/api/email/verify`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ token }),
+  });
+  if (!response.ok) {
+    throw new Error(await errorMessage(response, 'Failed to verify email'));
+  }
+  return await response.json();
+};
+
+export const resendVerificationEmail = async () => {
+  const response = await fetch(`${API_BASE_URL}/api/email/verify/resend`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error(await errorMessage(response, 'Failed to send verification email'));
+  }
+  return await response.json();
+};
@@ -0,0 +1,39 @@
+import { API_BASE_URL, getAuthHeaders } from '../config';
+
+export interface SecurityEvent {
+  id: number;
+  userId?: number;
+  type: string;
+  identifier?: string;
+  ipAddress: string;
+  userAgent: string;
+  details?: string;
+  createdAt: string;
+}
+
+// Pass userId to only get the events of one user
+export const fetchSecurityEvents = async (userId?: number, limit = 100): Promise<SecurityEvent[]> => {
+  const user = userId ? `&userId=${userId}` : '';
+  const response = await fetch(`${API_BASE_URL}/api/admin/security-events?limit=${limit}${user}`, {
+    headers: getAuthHeaders(),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to fetch security events');
+  }
+  return await response.json();
+};
+
+// user is a username or an email, ip lifts the lockouts of an IP address
+export const unlockAccount = async (unlock: { user?: string; ip?: string }) => {
+  const response = await fetch(`${API_BASE_URL}/api/admin/unlock`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify(unlock),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to unlock');
+  }
+  return await response.json();
+};
@@ -13,8 +13,21 @@ export const fetchAllChats = async () => {
   return await response.json();
 };
 
-export const fetchChat = async (userBName: string) => {
-  const response = await fetch(`${API_BASE_URL}/api/chat?userBName=${userBName}`, {
+export const fetchConversations = async () => {
+  const response = await fetch(`${API_BASE_URL}/api/chat/conversations`, {
+    headers: getAuthHeaders(),
+    credentials: 'include' as RequestCredentials,
+  });
+  if (!response.ok) {
+    throw new Error('Failed to fetch conversations');
+  }
+  return await response.json();
+};
+
+// Pass the ID of the oldest loaded message as before to load the previous page
+export const fetchChat = async (userBName: string, before?: number) => {
+  const cursor = before ? `&before=${before}` : '';
+  const response = await fetch(`${API_BASE_URL}/api/chat?userBName=${userBName}${cursor}`, {
     headers: getAuthHeaders(),
     credentials: 'include',
   });
@@ -24,8 +37,9 @@ export const fetchChat = async (userBName: string) => {
   return await response.json();
 };
 
-export const fetchGroupChat = async (groupId: string) => {
-  const response = await fetch(`${API_BASE_URL}/api/chat-group?groupId=${groupId}`, {
+export const fetchGroupChat = async (groupId: string, before?: number) => {
+  const cursor = before ? `&before=${before}` : '';
+  const response = await fetch(`${API_BASE_URL}/api/chat-group?groupId=${groupId}${cursor}`, {
     headers: getAuthHeaders(),
     credentials: 'include',
   });
@@ -79,6 +93,104 @@ export const markGroupChatAsRead = async (groupId: string) => {
   }
 };
 
+// Reacting again with the same reaction type removes the reaction
+export const reactToChatMessage = async (messageId: number, reactionTypeId: number) => {
+  const response = await fetch(`${API_BASE_URL}/api/chat/react`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ messageId, reactionTypeId }),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to react to message');
+  }
+  return await response.json();
+};
+
+// Mute a conversation (until mutedUntil, an ISO date, when given) or archive it until new messages arrive
+export const updateConversationSettings = async (settings: {
+  userId?: number, groupId?: number, muted: boolean, mutedUntil?: string, archived: boolean
+}) => {
+  const response = await fetch(`${API_BASE_URL}/api/chat/settings`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify(settings),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to update conversation settings');
+  }
+  return await response.json();
+};
+
+export const getMessageRequests = async (status: 'pending' | 'declined' = 'pending') => {
+  const response = await fetch(`${API_BASE_URL}/api/chat/requests?status=${status}`, {
+    headers: getAuthHeaders(),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to fetch message requests');
+  }
+  return await response.json();
+};
+
+export const respondToMessageRequest = async (userId: number, accept: boolean) => {
+  const response = await fetch(`${API_BASE_URL}/api/chat/requests`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ userId, accept }),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to update message request');
+  }
+  return await response.json();
+};
+
+export const updateGroupChatSettings = async (settings: {
+  groupId: number, announcementOnly: boolean, slowModeSeconds: number
+}) => {
+  const response = await fetch(`${API_BASE_URL}/api/group/chat/settings`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify(settings),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to update group chat settings');
+  }
+  return await response.json();
+};
+
+export const setGroupChatAdmin = async (groupId: number, userId: number, admin: boolean) => {
+  const response = await fetch(`${API_BASE_URL}/api/group/chat/admins`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ groupId, userId, admin }),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to update group admins');
+  }
+  return await response.json();
+};
+
+export const pinChatMessage = async (messageId: number, pinned: boolean) => {
+  const response = await fetch(
+    pinned ? `${API_BASE_URL}/api/group/chat/pins` : `${API_BASE_URL}/api/group/chat/pins?messageId=${messageId}`,
+    {
+      headers: getAuthHeaders(),
+      method: pinned ? 'POST' : 'DELETE',
+      body: pinned ? JSON.stringify({ messageId }) : undefined,
+      credentials: 'include',
+    }
+  );
+  if (!response.ok) {
+    throw new Error('Failed to update pinned messages');
+  }
+  return await response.json();
+};
+
 //export const checkAllowChat = async (userBName: string) => {
 //  const response = await fetch(`${API_BASE_URL}/api/chat/allow-chat?userBName=${userBName}`, {
 //    headers: getAuthHeaders(),
@@ -0,0 +1,45 @@
+import { API_BASE_URL, getAuthHeaders } from '../config';
+
+export interface Session {
+  id: string;
+  userAgent: string;
+  ipAddress: string;
+  createdAt: string;
+  lastUsedAt: string;
+  expiresAt: string;
+  current: boolean;
+}
+
+export const fetchSessions = async (): Promise<Session[]> => {
+  const response = await fetch(`${API_BASE_URL}/api/sessions`, {
+    headers: getAuthHeaders(),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to fetch sessions');
+  }
+  return await response.json();
+};
+
+export const revokeSession = async (id: string) => {
+  const response = await fetch(`${API_BASE_URL}/api/sessions?id=${encodeURIComponent(id)}`, {
+    headers: getAuthHeaders(),
+    method: 'DELETE',
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to revoke session');
+  }
+};
+
+export const revokeOtherSessions = async () => {
+  const response = await fetch(`${API_BASE_URL}/api/sessions/revoke-others`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to revoke sessions');
+  }
+  return await response.json();
+};
@@ -0,0 +1,64 @@
+import { API_BASE_URL, getAuthHeaders } from '../config';
+
+export const fetchTwoFactorStatus = async (): Promise<{ enabled: boolean; recoveryCodes: number }> => {
+  const response = await fetch(`${API_BASE_URL}/api/2fa`, {
+    headers: getAuthHeaders(),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to fetch two-factor settings');
+  }
+  return await response.json();
+};
+
+// Returns the secret and the otpauth:// URI to show as a QR code
+export const startTwoFactorSetup = async (): Promise<{ secret: string; uri: string }> => {
+  const response = await fetch(`${API_BASE_URL}/api/2fa/setup`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Failed to start two-factor setup');
+  }
+  return await response.json();
+};
+
+// Returns the recovery codes, they cannot be fetched again
+export const enableTwoFactor = async (code: string): Promise<{ recoveryCodes: string[] }> => {
+  const response = await fetch(`${API_BASE_URL}/api/2fa/enable`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ code }),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error('Invalid code');
+  }
+  return await response.json();
+};
+
+export const disableTwoFactor = async (password: string, code: string) => {
+  const response = await fetch(`${API_BASE_URL}/api/2fa/disable`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ password, code }),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error((await response.text()).trim() || 'Failed to disable two-factor authentication');
+  }
+};
+
+export const regenerateRecoveryCodes = async (password: string, code: string): Promise<{ recoveryCodes: string[] }> => {
+  const response = await fetch(`${API_BASE_URL}/api/2fa/recovery-codes`, {
+    headers: getAuthHeaders(),
+    method: 'POST',
+    body: JSON.stringify({ password, code }),
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    throw new Error((await response.text()).trim() || 'Failed to generate recovery codes');
+  }
+  return await response.json();
+};
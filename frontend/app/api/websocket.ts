@@ -1,14 +1,25 @@
 import { useEffect, useRef } from 'react';
 
 let socket: WebSocket | null = null; // Declare socket variable
+let lastSeq = 0; // Highest event sequence applied, survives reconnects of this page
+let resuming = false; // A resume was sent and its "resumed" or "resync" reply has not arrived yet
+
+// Ask the server to replay every event after the last one applied
+function resume() {
+    resuming = true;
+    socket?.send(JSON.stringify({ type: "resume", payload: { lastSeq } }));
+}
 
 export function initWebSocket(clientId: number, onMessage: (message: any) => void): WebSocket {
     // Initialize the WebSocket connection
 
-    socket = new WebSocket(`ws://localhost:8080/ws?userID=${clientId}`);
+    // The server identifies the user from the session cookie sent with the handshake
+    socket = new WebSocket(`ws://localhost:8080/ws`);
 
     socket.onopen = function(event) {
         console.log("WebSocket is open now.");
+        // Ask the server to replay anything pushed while we were disconnected
+        resume();
     };
 
     socket.onmessage = function(event) {
@@ -17,6 +28,22 @@ export function initWebSocket(clientId: number, onMessage: (message: any) => voi
             const messages = event.data.split('\n').filter((msg: string) => msg.trim() !== '');
             messages.forEach((msg: string) => {
                 const message = JSON.parse(msg);
+                if (message.type === "resumed" || message.type === "resync") {
+                    resuming = false;
+                    lastSeq = message.payload.seq;
+                } else if (message.seq) {
+                    if (message.seq <= lastSeq) {
+                        return; // Already applied, e.g. delivered live and replayed
+                    }
+                    if (message.seq > lastSeq + 1) {
+                        // Events were missed, the replay delivers them in order with this one
+                        if (!resuming) {
+                            resume();
+                        }
+                        return;
+                    }
+                    lastSeq = message.seq;
+                }
                 onMessage(message); // Call the provided onMessage function
             });
         } catch (error) {
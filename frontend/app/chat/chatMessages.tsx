@@ -40,7 +40,7 @@ const ChatMessages: React.FC<{ chat: Chat }> = ({ chat }) => {
   useEffect(() => {
     if (messages.length > 0) {
       messages.forEach((wsmsg: any) => {
-        if (wsmsg.type === "chat" && wsmsg.payload && 
+        if ((wsmsg.type === "chat" || wsmsg.type === "chat_muted") && wsmsg.payload && 
           (chat.userB.id !== 0 && 
             ((wsmsg.payload.senderId === chat.userB.id && wsmsg.payload.receiverId === chat.userA.id)
             ||(wsmsg.payload.receiverId === chat.userB.id && wsmsg.payload.senderId === chat.userA.id)))
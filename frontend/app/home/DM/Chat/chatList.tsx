@@ -13,6 +13,7 @@ export interface ChatMessage {
   groupId: number;
   content: string;
   createdAt: Date;
+  replyToId?: number;
 }
 
 export interface Chat {
@@ -92,7 +92,8 @@ const DirectMessages = () => {
     if (messages.length > 0) {
       // Handle websocket messages
       messages.forEach((wsmsg: any) => {
-        if (wsmsg.type === "chat" && wsmsg.payload) {
+        // Muted conversations still move up the list, but without raising their unread count
+        if ((wsmsg.type === "chat" || wsmsg.type === "chat_muted") && wsmsg.payload) {
           if (chats && chats.length > 0) {
             const newChats = chats
             // check for relevent chat based on user or group ids
@@ -111,7 +112,7 @@ const DirectMessages = () => {
                 markGroupChatAsRead(latestChat.group.id.toString())
               } else if (wsmsg.payload.receiverId !== 0 && pathName === `/chat/${encodeURIComponent(latestChat.userB.username)}`) {
                 markChatAsRead(latestChat.userB.username)
-              } else if (wsmsg.payload.senderId !== user?.id) {
+              } else if (wsmsg.type === "chat" && wsmsg.payload.senderId !== user?.id) {
                 latestChat.notification = (+latestChat.notification || +0) + 1
               }
               // put latest message at the start of the array
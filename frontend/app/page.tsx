@@ -5,11 +5,14 @@ import Image from "next/image";
 import styles from "./auth/auth.module.css";
 import profileImage from "./components/Images/ProfileImage.png";
 import { AuthContext } from "./auth/AuthProvider";
-import { login } from "./utils/authUtils";
+import { login, loginTwoFactor } from "./utils/authUtils";
 
 export default function Home() {
   const [emailOrUsername, setEmail] = useState("");
   const [password, setPassword] = useState("");
+  const [rememberMe, setRememberMe] = useState(false);
+  const [twoFactorToken, setTwoFactorToken] = useState<string | null>(null);
+  const [code, setCode] = useState("");
   const [error, setError] = useState<string | null>(null);
   const router = useRouter();
   const { isLoggedIn, setIsLoggedIn, setUser } = useContext(AuthContext);
@@ -29,7 +32,12 @@ export default function Home() {
       return;
     }
 
-    const { success, error, user } = await login(emailOrUsername, password);
+    const { success, error, user, twoFactorToken } = await login(emailOrUsername, password, rememberMe);
+
+    if (twoFactorToken) {
+      setTwoFactorToken(twoFactorToken);
+      return;
+    }
 
     if (success && user) {
       setIsLoggedIn(true);
@@ -41,6 +49,26 @@ export default function Home() {
     }
   };
 
+  const handleTwoFactorSubmit = async (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setError(null);
+
+    const { success, error, user } = await loginTwoFactor(twoFactorToken!, code);
+
+    if (success && user) {
+      setIsLoggedIn(true);
+      setUser(user);
+      router.push("/home");
+    } else {
+      setError(error || "Invalid code");
+      // The login is over once the password has to be entered again
+      if (error?.startsWith("Login expired")) {
+        setTwoFactorToken(null);
+        setCode("");
+      }
+    }
+  };
+
   if (isLoggedIn) {
     return null; // or a loading spinner
   }
@@ -58,6 +86,22 @@ export default function Home() {
           </div>
         </div>
         <div className={styles.errorMessage}>{error}</div>
+        {twoFactorToken ? (
+        <form onSubmit={handleTwoFactorSubmit} className={styles.LoginContainer}>
+          <div className={styles.inputContainer}>
+            <input
+              type="text"
+              autoComplete="one-time-code"
+              placeholder="Code from your authenticator app or a recovery code"
+              className={styles.inputField}
+              required
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+            />
+          </div>
+          <button type="submit" className={styles.submitBtn}>Verify</button>
+        </form>
+        ) : (
         <form onSubmit={handleSubmit} className={styles.LoginContainer}>
           <div className={styles.inputContainer}>
             <svg
@@ -99,8 +143,18 @@ export default function Home() {
               onChange={(e) => setPassword(e.target.value)}
             />
           </div>
+          <label>
+            <input
+              type="checkbox"
+              checked={rememberMe}
+              onChange={(e) => setRememberMe(e.target.checked)}
+            />
+            Remember me
+          </label>
           <button type="submit" className={styles.submitBtn}>Login</button>
+          <a onClick={() => router.push("/reset-password")}>Forgot password?</a>
         </form>
+        )}
       </div>
     </main>
   );
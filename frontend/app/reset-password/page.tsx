@@ -0,0 +1,102 @@
+"use client";
+import { useState, useEffect, FormEvent } from "react";
+import { useRouter } from "next/navigation";
+import styles from "../auth/auth.module.css";
+import { requestPasswordReset, resetPassword } from "../api/account";
+
+// Without a token this page asks for the email to send a reset link to, with the
+// token from that link it sets the new password
+export default function ResetPassword() {
+  const [token, setToken] = useState<string | null>(null);
+  const [email, setEmail] = useState("");
+  const [password, setPassword] = useState("");
+  const [confirmPassword, setConfirmPassword] = useState("");
+  const [error, setError] = useState<string | null>(null);
+  const [message, setMessage] = useState<string | null>(null);
+  const router = useRouter();
+
+  useEffect(() => {
+    setToken(new URLSearchParams(window.location.search).get("token"));
+  }, []);
+
+  const handleRequest = async (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setError(null);
+    try {
+      const data = await requestPasswordReset(email.trim());
+      setMessage(data.message);
+    } catch (err) {
+      setError((err as Error).message);
+    }
+  };
+
+  const handleReset = async (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setError(null);
+    if (password !== confirmPassword) {
+      setError("Passwords do not match.");
+      return;
+    }
+    try {
+      await resetPassword(token!, password);
+      router.push("/");
+    } catch (err) {
+      setError((err as Error).message);
+    }
+  };
+
+  return (
+    <main>
+      <div className={styles.container}>
+        <div className={styles.tabs}>
+          <div className={`${styles.tab} ${styles.active}`}>Reset password</div>
+          <div className={styles.tab} onClick={() => router.push("/")}>
+            Login
+          </div>
+        </div>
+        <div className={styles.errorMessage}>{error}</div>
+        {message ? (
+          <p>{message}</p>
+        ) : token ? (
+          <form onSubmit={handleReset} className={styles.LoginContainer}>
+            <div className={styles.inputContainer}>
+              <input
+                type="password"
+                placeholder="New password"
+                className={styles.inputField}
+                required
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+              />
+            </div>
+            <div className={styles.inputContainer}>
+              <input
+                type="password"
+                placeholder="Confirm new password"
+                className={styles.inputField}
+                required
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+              />
+            </div>
+            <button type="submit" className={styles.submitBtn}>Set password</button>
+          </form>
+        ) : (
+          <form onSubmit={handleRequest} className={styles.LoginContainer}>
+            <div className={styles.inputContainer}>
+              <input
+                type="email"
+                placeholder="Email"
+                className={styles.inputField}
+                required
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+              />
+            </div>
+            <button type="submit" className={styles.submitBtn}>Send reset link</button>
+          </form>
+        )}
+      </div>
+    </main>
+  );
+}
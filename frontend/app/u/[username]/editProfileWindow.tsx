@@ -27,6 +27,7 @@ const editProfileWindow: React.FC<EditProfileWindowProps> = ({ userData, onClose
   const [isAvatarDeleted, setIsAvatarDeleted] = useState(false);
   const [password, setPassword] = useState('');
   const [isPublic, setIsPublic] = useState(userData.isPublic);
+  const [messageRequests, setMessageRequests] = useState(userData.messageRequests || 'anyone');
   const [fileName, setFileName] = useState<string>(''); // Add state for file name
 
   const fileInputRef = useRef<HTMLInputElement | null>(null);
@@ -80,6 +81,7 @@ const editProfileWindow: React.FC<EditProfileWindowProps> = ({ userData, onClose
     formData.append("aboutMe", aboutMe);
     formData.append("password", password);
     formData.append("isPublic", isPublic.toString());
+    formData.append("messageRequests", messageRequests);
     formData.append("isAvatarDeleted", isAvatarDeleted.toString());
 
     if (selectedImage) {
@@ -309,6 +311,21 @@ const editProfileWindow: React.FC<EditProfileWindowProps> = ({ userData, onClose
                     Private
                   </div>
                 </div>
+                <p className={styles2.PrivacyTitle}>Message Requests From</p>
+                <div className={styles2.radioBtns}>
+                  {['anyone', 'followers', 'nobody'].map((option) => (
+                    <div key={option}>
+                      <input
+                        type="radio"
+                        className={styles2.radio}
+                        name="messageRequests"
+                        checked={messageRequests === option}
+                        onChange={() => setMessageRequests(option)}
+                      />
+                      {option.charAt(0).toUpperCase() + option.slice(1)}
+                    </div>
+                  ))}
+                </div>
 
               </div>
             </div>
@@ -15,6 +15,8 @@ export interface User {
   followers: number;
   isFollowed: boolean;
   notifications: number;
+  emailVerified: boolean;
+  twoFactor: boolean;
 }
 
 export const checkAuth = async (): Promise<{ isLoggedIn: boolean; user: User | null }> => {
@@ -57,14 +59,16 @@ export const logout = async (): Promise<boolean> => {
   }
 };
 
-export const login = async (emailOrUsername: string, password: string): Promise<{ success: boolean; error?: string; user?: User }> => {
+// With two-factor authentication on, login returns a twoFactorToken instead of the
+// user, to be sent to loginTwoFactor with the code
+export const login = async (emailOrUsername: string, password: string, rememberMe = false): Promise<{ success: boolean; error?: string; user?: User; twoFactorToken?: string }> => {
   try {
     const response = await fetch(`${API_BASE_URL}/login`, {
       method: "POST",
       headers: {
         "Content-Type": "application/json",
       },
-      body: JSON.stringify({ emailOrUsername, password }),
+      body: JSON.stringify({ emailOrUsername, password, rememberMe }),
       credentials: "include",
     });
 
@@ -81,6 +85,10 @@ export const login = async (emailOrUsername: string, password: string): Promise<
       return { success: false, error: data.error || "An error occurred during Login" };
     }
 
+    if (data.twoFactorRequired) {
+      return { success: false, twoFactorToken: data.twoFactorToken };
+    }
+
     if (!data.success || !data.user) {
       return { success: false, error: "Invalid response from server" };
     }
@@ -92,6 +100,30 @@ export const login = async (emailOrUsername: string, password: string): Promise<
   }
 };
 
+// code is from the authenticator app, or one of the recovery codes
+export const loginTwoFactor = async (token: string, code: string): Promise<{ success: boolean; error?: string; user?: User }> => {
+  try {
+    const response = await fetch(`${API_BASE_URL}/login/2fa`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({ token, code }),
+      credentials: "include",
+    });
+
+    const data = await response.json().catch(() => null);
+    if (!response.ok || !data?.success || !data.user) {
+      return { success: false, error: data?.error || "Invalid code" };
+    }
+
+    return { success: true, user: data.user };
+  } catch (error) {
+    console.error("Error during Login:", error);
+    return { success: false, error: "An error occurred during Login. Please try again." };
+  }
+};
+
 export const signup = async (formData: FormData): Promise<{ success: boolean; error?: string; user?: User }> => {
   try {
     const response = await fetch(`${API_BASE_URL}/signup`, {
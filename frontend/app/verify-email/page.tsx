@@ -0,0 +1,51 @@
+"use client";
+import { useState, useEffect, useContext } from "react";
+import { useRouter } from "next/navigation";
+import styles from "../auth/auth.module.css";
+import { verifyEmail } from "../api/account";
+import { AuthContext } from "../auth/AuthProvider";
+
+export default function VerifyEmail() {
+  const [status, setStatus] = useState("Verifying your email address...");
+  const [verified, setVerified] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+  const router = useRouter();
+  const { user, setUser } = useContext(AuthContext);
+
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get("token");
+    if (!token) {
+      setStatus("");
+      setError("This verification link is incomplete.");
+      return;
+    }
+    verifyEmail(token)
+      .then(() => {
+        setStatus("Your email address is verified.");
+        setVerified(true);
+      })
+      .catch((err) => {
+        setStatus("");
+        setError((err as Error).message);
+      });
+  }, []);
+
+  useEffect(() => {
+    // The logged in user no longer needs to be reminded to verify
+    if (verified && user && !user.emailVerified) {
+      setUser({ ...user, emailVerified: true });
+    }
+  }, [verified, user, setUser]);
+
+  return (
+    <main>
+      <div className={styles.container}>
+        <div className={styles.errorMessage}>{error}</div>
+        <p>{status}</p>
+        <button className={styles.submitBtn} onClick={() => router.push(user ? "/home" : "/")}>
+          Continue
+        </button>
+      </div>
+    </main>
+  );
+}